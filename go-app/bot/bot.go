@@ -2,6 +2,7 @@ package bot
 
 import (
 	"cloud.google.com/go/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
@@ -9,12 +10,40 @@ type Bot struct {
 	LINEBotClient   *linebot.Client
 	DatastoreClient *datastore.Client
 	GCPPlacesAPIKey string
+	// グループで「@ボット名」と呼びかけられたときに使う表示名
+	Name           string
+	DistanceMatrix places.DistanceMatrixProvider
+	Geocoder       places.Geocoder
+	PlaceFinder    places.PlaceFinder
+	// 署名付きURLの公開URLと署名鍵
+	BaseURL       string
+	URLSigningKey []byte
+	// 定期実行ジョブの認証トークン
+	JobToken string
+	// 種類ごとのリッチメニューID
+	RichMenus map[string]string
 }
 
-func NewBot(linebotClient *linebot.Client, datastoreClient *datastore.Client, gcpPlacesAPIKey string) *Bot {
+// 環境ごとの設定
+type Settings struct {
+	GCPPlacesAPIKey string
+	Name            string
+	BaseURL         string
+	URLSigningKey   string
+	JobToken        string
+}
+
+func NewBot(linebotClient *linebot.Client, datastoreClient *datastore.Client, settings Settings) *Bot {
 	return &Bot{
 		LINEBotClient:   linebotClient,
 		DatastoreClient: datastoreClient,
-		GCPPlacesAPIKey: gcpPlacesAPIKey,
+		GCPPlacesAPIKey: settings.GCPPlacesAPIKey,
+		Name:            settings.Name,
+		DistanceMatrix:  places.NewGoogleDistanceMatrix(settings.GCPPlacesAPIKey),
+		Geocoder:        places.NewGoogleGeocoder(settings.GCPPlacesAPIKey),
+		PlaceFinder:     places.NewGoogleFindPlace(settings.GCPPlacesAPIKey),
+		BaseURL:         settings.BaseURL,
+		URLSigningKey:   []byte(settings.URLSigningKey),
+		JobToken:        settings.JobToken,
 	}
 }
@@ -0,0 +1,403 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/golang/protobuf/proto"
+	"github.com/line/line-bot-sdk-go/linebot"
+	pb "google.golang.org/genproto/googleapis/datastore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// テスト用のDatastore．エンティティをメモリに持ち，トランザクションは楽観的に扱う
+type fakeDatastore struct {
+	pb.UnimplementedDatastoreServer
+
+	mu       sync.Mutex
+	entities map[string]*pb.Entity
+	versions map[string]int64
+	// トランザクションごとに読んだエンティティのバージョン
+	txs    map[string]map[string]int64
+	nextID int64
+	// trueなら書き込みを失敗させる
+	FailCommit bool
+}
+
+func entityKey(key *pb.Key) string {
+	parts := []string{}
+	for _, e := range key.Path {
+		if e.GetName() != "" {
+			parts = append(parts, e.Kind+":"+e.GetName())
+		} else {
+			parts = append(parts, fmt.Sprintf("%s#%d", e.Kind, e.GetId()))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (f *fakeDatastore) Lookup(ctx context.Context, req *pb.LookupRequest) (*pb.LookupResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := &pb.LookupResponse{}
+	for _, key := range req.Keys {
+		k := entityKey(key)
+		if tx := req.GetReadOptions().GetTransaction(); tx != nil {
+			if read, ok := f.txs[string(tx)]; ok {
+				read[k] = f.versions[k]
+			}
+		}
+		if e, ok := f.entities[k]; ok {
+			res.Found = append(res.Found, &pb.EntityResult{Entity: proto.Clone(e).(*pb.Entity), Version: f.versions[k]})
+		} else {
+			res.Missing = append(res.Missing, &pb.EntityResult{Entity: &pb.Entity{Key: key}})
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeDatastore) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	tx := fmt.Sprintf("tx%d", f.nextID)
+	f.txs[tx] = map[string]int64{}
+	return &pb.BeginTransactionResponse{Transaction: []byte(tx)}, nil
+}
+
+func (f *fakeDatastore) Rollback(ctx context.Context, req *pb.RollbackRequest) (*pb.RollbackResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.txs, string(req.Transaction))
+	return &pb.RollbackResponse{}, nil
+}
+
+func (f *fakeDatastore) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.FailCommit {
+		return nil, status.Error(codes.Internal, "commit is disabled")
+	}
+	if tx := req.GetTransaction(); tx != nil {
+		read, ok := f.txs[string(tx)]
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "unknown transaction")
+		}
+		delete(f.txs, string(tx))
+		// 読んだ後にほかで書き換えられていればやり直させる
+		for k, v := range read {
+			if f.versions[k] != v {
+				return nil, status.Error(codes.Aborted, "conflict")
+			}
+		}
+	}
+	res := &pb.CommitResponse{}
+	for _, m := range req.Mutations {
+		var e *pb.Entity
+		switch {
+		case m.GetUpsert() != nil:
+			e = m.GetUpsert()
+		case m.GetInsert() != nil:
+			e = m.GetInsert()
+		case m.GetUpdate() != nil:
+			e = m.GetUpdate()
+		case m.GetDelete() != nil:
+			k := entityKey(m.GetDelete())
+			delete(f.entities, k)
+			f.versions[k]++
+			res.MutationResults = append(res.MutationResults, &pb.MutationResult{})
+			continue
+		}
+		e = proto.Clone(e).(*pb.Entity)
+		last := e.Key.Path[len(e.Key.Path)-1]
+		var key *pb.Key
+		if last.GetName() == "" && last.GetId() == 0 {
+			f.nextID++
+			last.IdType = &pb.Key_PathElement_Id{Id: f.nextID}
+			key = e.Key
+		}
+		k := entityKey(e.Key)
+		f.entities[k] = e
+		f.versions[k]++
+		res.MutationResults = append(res.MutationResults, &pb.MutationResult{Key: key, Version: f.versions[k]})
+	}
+	return res, nil
+}
+
+// 種類と単一プロパティの比較(AND)だけに対応する
+func (f *fakeDatastore) RunQuery(ctx context.Context, req *pb.RunQueryRequest) (*pb.RunQueryResponse, error) {
+	q := req.GetQuery()
+	if q == nil || len(q.Kind) != 1 {
+		return nil, status.Error(codes.Unimplemented, "unsupported query")
+	}
+	filters := []*pb.PropertyFilter{}
+	if pf := q.GetFilter().GetPropertyFilter(); pf != nil {
+		filters = append(filters, pf)
+	}
+	for _, sub := range q.GetFilter().GetCompositeFilter().GetFilters() {
+		filters = append(filters, sub.GetPropertyFilter())
+	}
+	keysOnly := len(q.Projection) == 1 && q.Projection[0].GetProperty().GetName() == "__key__"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := []string{}
+	for k := range f.entities {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	batch := &pb.QueryResultBatch{
+		EntityResultType: pb.EntityResult_FULL,
+		MoreResults:      pb.QueryResultBatch_NO_MORE_RESULTS,
+	}
+	if keysOnly {
+		batch.EntityResultType = pb.EntityResult_KEY_ONLY
+	}
+	for _, k := range names {
+		e := f.entities[k]
+		if e.Key.Path[len(e.Key.Path)-1].Kind != q.Kind[0].Name || !matchFilters(e, filters) {
+			continue
+		}
+		result := proto.Clone(e).(*pb.Entity)
+		if keysOnly {
+			result = &pb.Entity{Key: result.Key}
+		}
+		batch.EntityResults = append(batch.EntityResults, &pb.EntityResult{Entity: result, Version: f.versions[k]})
+	}
+	return &pb.RunQueryResponse{Batch: batch}, nil
+}
+
+func matchFilters(e *pb.Entity, filters []*pb.PropertyFilter) bool {
+	for _, pf := range filters {
+		v, ok := e.Properties[pf.GetProperty().GetName()]
+		// インデックスのないプロパティは絞り込みに使えない
+		if !ok || v.ExcludeFromIndexes {
+			return false
+		}
+		c, ok := compareValues(v, pf.Value)
+		if !ok {
+			return false
+		}
+		switch pf.Op {
+		case pb.PropertyFilter_LESS_THAN:
+			ok = c < 0
+		case pb.PropertyFilter_LESS_THAN_OR_EQUAL:
+			ok = c <= 0
+		case pb.PropertyFilter_GREATER_THAN:
+			ok = c > 0
+		case pb.PropertyFilter_GREATER_THAN_OR_EQUAL:
+			ok = c >= 0
+		case pb.PropertyFilter_EQUAL:
+			ok = c == 0
+		default:
+			ok = false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func compareValues(a, b *pb.Value) (int, bool) {
+	switch av := a.ValueType.(type) {
+	case *pb.Value_TimestampValue:
+		bv, ok := b.ValueType.(*pb.Value_TimestampValue)
+		if !ok {
+			return 0, false
+		}
+		at, bt := av.TimestampValue, bv.TimestampValue
+		if at.Seconds != bt.Seconds {
+			return sign(at.Seconds - bt.Seconds), true
+		}
+		return sign(int64(at.Nanos - bt.Nanos)), true
+	case *pb.Value_StringValue:
+		bv, ok := b.ValueType.(*pb.Value_StringValue)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av.StringValue, bv.StringValue), true
+	case *pb.Value_IntegerValue:
+		bv, ok := b.ValueType.(*pb.Value_IntegerValue)
+		if !ok {
+			return 0, false
+		}
+		return sign(av.IntegerValue - bv.IntegerValue), true
+	}
+	return 0, false
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// 保存されているエンティティの数
+func (f *fakeDatastore) Len(kind string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for k := range f.entities {
+		if strings.HasPrefix(k, kind+":") || strings.HasPrefix(k, kind+"#") {
+			n++
+		}
+	}
+	return n
+}
+
+func newFakeDatastore(t *testing.T) (*datastore.Client, *fakeDatastore) {
+	t.Helper()
+	fake := &fakeDatastore{
+		entities: map[string]*pb.Entity{},
+		versions: map[string]int64{},
+		txs:      map[string]map[string]int64{},
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterDatastoreServer(server, fake)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	// エミュレータとして接続する
+	prev, had := os.LookupEnv("DATASTORE_EMULATOR_HOST")
+	os.Setenv("DATASTORE_EMULATOR_HOST", lis.Addr().String())
+	client, err := datastore.NewClient(context.Background(), "test")
+	if had {
+		os.Setenv("DATASTORE_EMULATOR_HOST", prev)
+	} else {
+		os.Unsetenv("DATASTORE_EMULATOR_HOST")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, fake
+}
+
+// テスト用のMessaging API．送信されたメッセージを記録する
+type fakeLINE struct {
+	mu      sync.Mutex
+	replies map[string][]json.RawMessage
+	pushes  map[string][]json.RawMessage
+}
+
+func (f *fakeLINE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		ReplyToken string            `json:"replyToken"`
+		To         string            `json:"to"`
+		Messages   []json.RawMessage `json:"messages"`
+	}{}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/v2/bot/message/reply":
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.replies[req.ReplyToken] = req.Messages
+	case "/v2/bot/message/push":
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.pushes[req.To] = append(f.pushes[req.To], req.Messages...)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+// 返信したメッセージ
+func (f *fakeLINE) Reply(token string) []json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.replies[token]
+}
+
+// プッシュ送信したメッセージ
+func (f *fakeLINE) Pushes(to string) []json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pushes[to]
+}
+
+// 返信したテキスト
+func (f *fakeLINE) ReplyTexts(token string) []string {
+	texts := []string{}
+	for _, m := range f.Reply(token) {
+		msg := struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{}
+		if err := json.Unmarshal(m, &msg); err == nil && msg.Type == "text" {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+// 偽のDatastoreとMessaging APIにつないだボット
+func newTestBot(t *testing.T) (*Bot, *fakeDatastore, *fakeLINE) {
+	t.Helper()
+	dsClient, ds := newFakeDatastore(t)
+	line := &fakeLINE{replies: map[string][]json.RawMessage{}, pushes: map[string][]json.RawMessage{}}
+	server := httptest.NewServer(line)
+	t.Cleanup(server.Close)
+	lineClient, err := NewLINEClient("secret", "token", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBot(lineClient, dsClient, Settings{Name: "testbot"}), ds, line
+}
+
+// 1対1のトークのイベント
+func userEvent(userID, replyToken string) *linebot.Event {
+	return &linebot.Event{
+		ReplyToken: replyToken,
+		Source:     &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: userID},
+	}
+}
+
+// グループのトークのイベント
+func groupEvent(groupID, userID, replyToken string) *linebot.Event {
+	return &linebot.Event{
+		ReplyToken: replyToken,
+		Source:     &linebot.EventSource{Type: linebot.EventSourceTypeGroup, GroupID: groupID, UserID: userID},
+	}
+}
+
+// ボタンを押したイベント
+func postbackEvent(userID, replyToken, data string) *linebot.Event {
+	event := userEvent(userID, replyToken)
+	event.Type = linebot.EventTypePostback
+	event.Postback = &linebot.Postback{Data: data}
+	return event
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+)
+
+// 期限切れのエンティティの削除結果(種類ごとの削除数)
+type CleanupResult struct {
+	Queries        int `json:"queries"`
+	Conversations  int `json:"conversations"`
+	PostbackStates int `json:"postback_states"`
+	Links          int `json:"links"`
+	InviteCodes    int `json:"invite_codes"`
+	InviteFailures int `json:"invite_failures"`
+	// デフォルトに戻したリッチメニュー
+	RichMenus int `json:"rich_menus"`
+	Failed    int `json:"failed"`
+}
+
+// スコープの検索条件を読み込む．期限切れならないものとして扱う
+func (bot *Bot) getQuery(ctx context.Context, scopeKey string, q *Query) error {
+	if err := mystore.Get(ctx, bot.DatastoreClient, q, scopeKey, nil); err != nil {
+		return err
+	}
+	if q.Expired(time.Now()) {
+		return datastore.ErrNoSuchEntity
+	}
+	return nil
+}
+
+// CleanupExpired deletes expired queries, conversation states, postback states, links and invite codes,
+// and switches back the rich menus left in the searching menu.
+// 期限のプロパティの不等式で期限切れのキーだけを取り出す(1件ずつ読み込まない)
+func (bot *Bot) CleanupExpired(ctx context.Context) (*CleanupResult, error) {
+	result := CleanupResult{}
+	now := time.Now()
+	kinds := []struct {
+		kind     string
+		property string
+		before   time.Time
+		count    *int
+	}{
+		// 検索条件は最後に保存してからQueryTTLで期限切れ
+		{"Query", "updated_at", now.Add(-QueryTTL), &result.Queries},
+		{"Conversation", "expires_at", now, &result.Conversations},
+		{"PostbackState", "expires_at", now, &result.PostbackStates},
+		{"LinkTarget", "expires_at", now, &result.Links},
+		{"InviteCode", "expires_at", now, &result.InviteCodes},
+		{"InviteFailure", "expires_at", now, &result.InviteFailures},
+	}
+	// 検索中のメニューのままのユーザを戻す
+	unlinked, failed, err := bot.unlinkExpiredRichMenus(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	result.RichMenus = unlinked
+	result.Failed += failed
+	for _, k := range kinds {
+		q := datastore.NewQuery(k.kind).Filter(k.property+" <", k.before).KeysOnly()
+		keys, err := bot.DatastoreClient.GetAll(ctx, q, nil)
+		if err != nil {
+			return nil, err
+		}
+		bot.deleteKeys(ctx, keys, k.count, &result)
+	}
+	// 更新日時を記録する前に保存された検索条件や期限を記録する前の招待コードはすでに期限切れとして扱っているが，
+	// 不等式では取り出せないので別に探して削除する
+	untimed := []struct {
+		kind     string
+		property string
+		count    *int
+	}{
+		{"Query", "updated_at", &result.Queries},
+		{"InviteCode", "expires_at", &result.InviteCodes},
+	}
+	for _, k := range untimed {
+		keys, err := bot.keysWithout(ctx, k.kind, k.property)
+		if err != nil {
+			return nil, err
+		}
+		bot.deleteKeys(ctx, keys, k.count, &result)
+	}
+	return &result, nil
+}
+
+// プロパティを持たないエンティティのキー．
+// プロパティのないエンティティはそのプロパティのインデックスに載らないので，すべてのキーから除いて求める
+func (bot *Bot) keysWithout(ctx context.Context, kind, property string) ([]*datastore.Key, error) {
+	all, err := bot.DatastoreClient.GetAll(ctx, datastore.NewQuery(kind).KeysOnly(), nil)
+	if err != nil {
+		return nil, err
+	}
+	q := datastore.NewQuery(kind).Filter(property+" >=", time.Time{}).KeysOnly()
+	with, err := bot.DatastoreClient.GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	has := map[string]bool{}
+	for _, key := range with {
+		has[key.String()] = true
+	}
+	keys := []*datastore.Key{}
+	for _, key := range all {
+		if !has[key.String()] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// キーを削除して数える
+func (bot *Bot) deleteKeys(ctx context.Context, keys []*datastore.Key, count *int, result *CleanupResult) {
+	// 一度に削除できるのは500件まで
+	for start := 0; start < len(keys); start += 500 {
+		end := start + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := bot.DatastoreClient.DeleteMulti(ctx, keys[start:end]); err != nil {
+			log.Print(err)
+			result.Failed += end - start
+			continue
+		}
+		*count += end - start
+	}
+}
+
+// Cloud Schedulerから定期実行して，期限切れの検索条件と入力待ちとボタンのデータを削除する
+func (bot *Bot) CleanupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bot.acceptJob(w, r) {
+			return
+		}
+		result, err := bot.CleanupExpired(r.Context())
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
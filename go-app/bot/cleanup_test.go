@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+)
+
+// 更新日時を記録する前の検索条件
+type legacyQuery struct {
+	Lat string `datastore:"lat,noindex"`
+	Lng string `datastore:"lng,noindex"`
+}
+
+func TestCleanupExpiredQueries(t *testing.T) {
+	ctx := context.Background()
+	bot, ds, _ := newTestBot(t)
+
+	legacy := legacyQuery{Lat: "35", Lng: "139"}
+	if _, err := bot.DatastoreClient.Put(ctx, (&Query{}).NameKey("legacy", nil), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	expired := Query{Lat: "35", Lng: "139"}
+	expired.Touch(time.Now().Add(-QueryTTL - time.Minute))
+	if _, err := bot.DatastoreClient.Put(ctx, expired.NameKey("expired", nil), &expired); err != nil {
+		t.Fatal(err)
+	}
+	fresh := Query{Lat: "35", Lng: "139"}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &fresh, "fresh", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := bot.CleanupExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Queries != 2 || result.Failed != 0 {
+		t.Errorf("result = %+v, want 2 queries deleted", result)
+	}
+	if n := ds.Len("Query"); n != 1 {
+		t.Errorf("%d queries are left, want 1", n)
+	}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &Query{}, "fresh", nil); err != nil {
+		t.Errorf("fresh query is deleted: %v", err)
+	}
+}
+
+// 期限を記録する前の招待コード
+type legacyInviteCode struct {
+	ListID string `datastore:"list_id,noindex"`
+}
+
+func TestCleanupExpiredInviteCodes(t *testing.T) {
+	ctx := context.Background()
+	bot, ds, _ := newTestBot(t)
+
+	legacy := legacyInviteCode{ListID: "G1"}
+	if _, err := bot.DatastoreClient.Put(ctx, (&InviteCode{}).NameKey("LEGACY", nil), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	codes := map[string]time.Time{
+		"EXPIRE": time.Now().Add(-time.Minute),
+		"VALID2": time.Now().Add(time.Hour),
+	}
+	for code, expiresAt := range codes {
+		invite := InviteCode{ListID: "G1", ExpiresAt: expiresAt}
+		if err := mystore.Save(ctx, bot.DatastoreClient, &invite, code, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := bot.CleanupExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InviteCodes != 2 {
+		t.Errorf("result = %+v, want 2 invite codes deleted", result)
+	}
+	if n := ds.Len("InviteCode"); n != 1 {
+		t.Errorf("%d invite codes are left, want 1", n)
+	}
+}
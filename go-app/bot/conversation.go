@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/nlquery"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/richmenu"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 入力待ちを取り消すコマンドと，検索確認ウィンドウに戻るコマンド
+const (
+	CancelCommand = "キャンセル"
+	BackCommand   = "戻る"
+)
+
+// 入力待ちの有効期間
+const ConversationTTL = 10 * time.Minute
+
+// ボットが直前に求めた入力
+type ConversationState string
+
+const (
+	StateIdle                 ConversationState = ""
+	StateAwaitingKeyword      ConversationState = "awaitingKeyword"
+	StateAwaitingRadius       ConversationState = "awaitingRadius"
+	StateAwaitingLocationName ConversationState = "awaitingLocationName"
+)
+
+// 会話の状態
+type Conversation struct {
+	State     ConversationState `datastore:"state,noindex"`
+	ExpiresAt time.Time         `datastore:"expires_at"`
+	mystore.Timestamp
+}
+
+func (c *Conversation) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("Conversation", name, parent)
+}
+
+// 入力待ちの期限を過ぎたか
+func (c *Conversation) Expired(now time.Time) bool {
+	return now.After(c.ExpiresAt)
+}
+
+// 今の会話の状態．期限切れなら待っていないものとする
+func (bot *Bot) conversationState(ctx context.Context, scope Scope) ConversationState {
+	c := Conversation{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &c, scope.Key(), nil); err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			log.Print(err)
+		}
+		return StateIdle
+	}
+	if c.Expired(time.Now()) {
+		return StateIdle
+	}
+	return c.State
+}
+
+// 入力待ちにする(期限は延びる)
+func (bot *Bot) StartConversation(ctx context.Context, scope Scope, state ConversationState) {
+	c := Conversation{State: state, ExpiresAt: time.Now().Add(ConversationTTL)}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &c, scope.Key(), nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// 入力待ちを終える
+func (bot *Bot) EndConversation(ctx context.Context, scope Scope) {
+	if err := mystore.Delete(ctx, bot.DatastoreClient, &Conversation{}, scope.Key(), nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// 入力待ちの取り消し方の案内
+func conversationHint(scope Scope) string {
+	prefix := ""
+	if scope.IsGroup() {
+		prefix = GroupCommandPrefix
+	}
+	return "「" + prefix + BackCommand + "」で確認画面に戻る，「" + prefix + CancelCommand + "」で入力をやめます"
+}
+
+// 入力待ちと設定中の検索条件を取り消す
+func (bot *Bot) CancelConversation(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	q := Query{}
+	searching := bot.getQuery(ctx, scope.Key(), &q) == nil
+	if bot.conversationState(ctx, scope) == StateIdle && !searching {
+		bot.ReplyMessage(ctx, event, TextMessage("取り消す操作はありません"))
+		return
+	}
+	bot.EndConversation(ctx, scope)
+	if searching {
+		if err := mystore.Delete(ctx, bot.DatastoreClient, &Query{}, scope.Key(), nil); err != nil {
+			log.Print(err)
+		}
+	}
+	bot.SwitchRichMenu(ctx, event, richmenu.Default)
+	bot.ReplyMessage(ctx, event, TextMessage("キャンセルしました"))
+}
+
+// 入力待ちをやめて検索確認ウィンドウに戻る
+func (bot *Bot) BackConversation(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	bot.EndConversation(ctx, scope)
+	q := Query{}
+	if err := bot.getQuery(ctx, scope.Key(), &q); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("戻る画面がありません．位置情報を送信してください"))
+		return
+	}
+	bot.ReplyMessage(ctx, event, SearchConfirmWindow(&q).WithQuickReplies(KeywordQuickReplyItems(&q)))
+}
+
+// 入力待ちなら求めた入力として扱い，trueを返す
+func (bot *Bot) HandleAwaitedInput(ctx context.Context, event *linebot.Event, text string) bool {
+	scope := NewScope(event.Source)
+	switch bot.conversationState(ctx, scope) {
+	case StateAwaitingKeyword:
+		// 続けて送られたキーワードも追加できるように待ち続ける
+		bot.StartConversation(ctx, scope, StateAwaitingKeyword)
+		bot.AddKeyword(ctx, event, text)
+	case StateAwaitingRadius:
+		// 数字だけならメートルとみなす
+		if _, err := strconv.Atoi(text); err == nil {
+			text += "m"
+		}
+		r := nlquery.Parse(text)
+		if r.Radius == 0 {
+			bot.ReplyMessage(ctx, event, TextMessage("「800m」「1.5km」のように距離を入力してください\n"+conversationHint(scope)))
+			return true
+		}
+		if r.Radius <= places.MaxRadius {
+			bot.EndConversation(ctx, scope)
+		}
+		bot.SearchByText(ctx, event, &r)
+	case StateAwaitingLocationName:
+		bot.RegisterLocationName(ctx, event, text)
+	default:
+		return false
+	}
+	return true
+}
@@ -1,6 +1,8 @@
 package bot
 
 import (
+	"time"
+
 	"cloud.google.com/go/datastore"
 	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
 	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
@@ -10,11 +12,31 @@ import (
 
 // nearbySearchのクエリ
 type Query struct {
-	Lat      string   `json:"lat" datastore:"lat,noindex"`
-	Lng      string   `json:"lng" datastore:"lng,noindex"`
+	Lat string `json:"lat" datastore:"lat,noindex"`
+	Lng string `json:"lng" datastore:"lng,noindex"`
+	// 地名や住所から検索したときの住所
+	Address  string   `json:"address,omitempty" datastore:"address,noindex"`
 	Keywords []string `json:"keywords" datastore:"keywords,noindex"`
 	Radius   string   `json:"radius" datastore:"raduis,noindex"`
 	Page     int      `json:"page" datastore:"page,noindex"`
+	// 移動時間の表示と並び替え
+	TravelMode       places.TravelMode `json:"mode,omitempty" datastore:"travel_mode,noindex"`
+	SortByTravelTime bool              `json:"sort,omitempty" datastore:"sort_by_travel_time,noindex"`
+	// 価格帯の上限(1〜4)，営業中のみ，お店の種類(Places APIのtype)
+	MaxPrice int    `json:"price,omitempty" datastore:"max_price,noindex"`
+	OpenNow  bool   `json:"open,omitempty" datastore:"open_now,noindex"`
+	Type     string `json:"type,omitempty" datastore:"type,noindex"`
+
+	// 保存した日時．古い検索条件は使わない
+	mystore.Timestamp `json:"-"`
+}
+
+// 検索条件を使い回す期間．これより古い位置情報は送り直してもらう
+const QueryTTL = 24 * time.Hour
+
+// 有効期間を過ぎたか．保存日時のない古いエンティティも期限切れとみなす
+func (query *Query) Expired(now time.Time) bool {
+	return now.Sub(query.UpdatedAt) > QueryTTL
 }
 
 func NewQuery(lat, lng string) Query {
@@ -32,12 +54,189 @@ func (query *Query) NameKey(name string, parent *datastore.Key) *datastore.Key {
 	return datastore.NameKey("Query", name, parent)
 }
 
+// 最後に実行した検索
+type LastSearch struct {
+	Query      Query     `datastore:"query,noindex"`
+	SearchedAt time.Time `datastore:"searched_at,noindex"`
+	mystore.Timestamp
+}
+
+func (last *LastSearch) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("LastSearch", name, parent)
+}
+
+// 前回の条件を別の地点のクエリに引き継ぐ
+func (last *LastSearch) ApplyTo(q *Query) {
+	q.Keywords = append([]string{}, last.Query.Keywords...)
+	q.Radius = last.Query.Radius
+	q.TravelMode = last.Query.TravelMode
+	q.SortByTravelTime = last.Query.SortByTravelTime
+	q.MaxPrice = last.Query.MaxPrice
+	q.OpenNow = last.Query.OpenNow
+	q.Type = last.Query.Type
+}
+
 // ユーザのお気に入り
 type Favorite struct {
-	List []places.Place `datastore:"list,noindex"`
+	List []FavoriteItem `datastore:"list,noindex"`
+	// キーはハッシュ化されているので，通知のためにユーザIDを持っておく
+	UserID string `datastore:"user_id,noindex"`
+	mystore.Timestamp
+}
+
+// お気に入りのお店とユーザがつけた情報
+type FavoriteItem struct {
+	places.Place
+	Tags     []string `datastore:"tags,noindex"`
+	Memo     string   `datastore:"memo,noindex"`
+	MyRating int      `datastore:"my_rating,noindex"`
+	// 共有リストに追加したユーザの表示名
+	AddedBy string `datastore:"added_by,noindex"`
+}
+
+// タグがついているか
+func (item *FavoriteItem) HasTag(tag string) bool {
+	for _, t := range item.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// タグをつけ外しする
+func (item *FavoriteItem) ToggleTag(tag string) {
+	if !item.HasTag(tag) {
+		item.Tags = append(item.Tags, tag)
+		return
+	}
+	tags := []string{}
+	for _, t := range item.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	item.Tags = tags
+}
+
+// リスト内のお店を探す
+func FindFavoriteItem(list []FavoriteItem, placeID string) *FavoriteItem {
+	for i := range list {
+		if list[i].PlaceID == placeID {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// タグで絞り込む
+func FilterFavoriteItems(list []FavoriteItem, tag string) []FavoriteItem {
+	if tag == "" {
+		return list
+	}
+	filtered := []FavoriteItem{}
+	for _, item := range list {
+		if item.HasTag(tag) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// リスト内で使われているタグ
+func FavoriteTags(list []FavoriteItem) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, item := range list {
+		for _, t := range item.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// お気に入りを編集中のユーザが対象にしているお店
+// キーはユーザID
+type FavoriteEdit struct {
+	ListID  string `datastore:"list_id,noindex"`
+	PlaceID string `datastore:"place_id,noindex"`
+	mystore.Timestamp
+}
+
+func (edit *FavoriteEdit) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("FavoriteEdit", name, parent)
 }
 
 func (favorite *Favorite) NameKey(name string, parent *datastore.Key) *datastore.Key {
 	name = mystore.HashedString(name)
 	return datastore.NameKey("Favorite", name, parent)
 }
+
+// ユーザが名前をつけて登録した地点
+type SavedLocation struct {
+	Name    string `json:"name" datastore:"name,noindex"`
+	Lat     string `json:"lat" datastore:"lat,noindex"`
+	Lng     string `json:"lng" datastore:"lng,noindex"`
+	Address string `json:"address,omitempty" datastore:"address,noindex"`
+}
+
+// 登録地点のリスト
+type SavedLocations struct {
+	List []SavedLocation `datastore:"list,noindex"`
+	// 名前の入力を待っている地点
+	Pending SavedLocation `datastore:"pending,noindex"`
+	mystore.Timestamp
+}
+
+func (locations *SavedLocations) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("SavedLocations", name, parent)
+}
+
+// 名前で登録地点を探す
+func (locations *SavedLocations) Find(name string) (SavedLocation, bool) {
+	for _, l := range locations.List {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return SavedLocation{}, false
+}
+
+// 登録地点を追加する．同じ名前があれば上書きする
+func (locations *SavedLocations) Put(location SavedLocation) {
+	for i := range locations.List {
+		if locations.List[i].Name == location.Name {
+			locations.List[i] = location
+			return
+		}
+	}
+	locations.List = append(locations.List, location)
+}
+
+// 名前で登録地点を削除する
+func (locations *SavedLocations) Remove(name string) bool {
+	newList := []SavedLocation{}
+	had := false
+	for _, l := range locations.List {
+		if l.Name == name {
+			had = true
+		} else {
+			newList = append(newList, l)
+		}
+	}
+	locations.List = newList
+	return had
+}
+
+// 登録地点を検索クエリにする
+func (l *SavedLocation) Query() Query {
+	q := NewQuery(l.Lat, l.Lng)
+	q.Address = l.Name
+	return q
+}
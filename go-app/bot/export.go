@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/export"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/signedurl"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// エクスポート用リンクの有効期間
+const ExportLinkTTL = 15 * time.Minute
+
+// エクスポートのパス
+const ExportPath = "/export"
+
+// エクスポート用URLのパラメータ
+const exportParamFormat = "format"
+
+// お気に入りを書き出し用に変換
+func ExportRecords(list []FavoriteItem) []export.Record {
+	records := make([]export.Record, 0, len(list))
+	for _, item := range list {
+		records = append(records, export.Record{
+			Name:     item.Name,
+			Lat:      item.Lat,
+			Lng:      item.Lng,
+			Address:  item.Address,
+			URL:      item.GooglemapURI,
+			Tags:     item.Tags,
+			Memo:     item.Memo,
+			MyRating: item.MyRating,
+		})
+	}
+	return records
+}
+
+// 書き出すリスト．グループではグループの共有リスト
+func exportTarget(scope Scope) *LinkTarget {
+	target := LinkTarget{Purpose: LinkPurposeExport}
+	if scope.IsGroup() {
+		target.ListID = scope.Key()
+	} else {
+		target.UserID = scope.UserID
+	}
+	return &target
+}
+
+// 形式ごとの署名付きURL
+func (bot *Bot) exportURLs(token string, expires time.Time) map[export.Format]string {
+	urls := map[export.Format]string{}
+	for _, f := range export.Formats {
+		p := url.Values{
+			linkParamToken:    {token},
+			exportParamFormat: {string(f)},
+		}
+		urls[f] = signedurl.URL(bot.URLSigningKey, bot.BaseURL, ExportPath, p, expires)
+	}
+	return urls
+}
+
+// エクスポート用のリンクを送る
+func (bot *Bot) ShowExportLinks(ctx context.Context, event *linebot.Event) {
+	if !bot.signedLinksEnabled() {
+		bot.ReplyMessage(ctx, event, TextMessage("エクスポートは利用できません"))
+		return
+	}
+	target := exportTarget(NewScope(event.Source))
+	list, err := bot.exportList(ctx, target)
+	if err != nil || len(list) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入りがありません"))
+		return
+	}
+	token, err := bot.issueLink(ctx, target, ExportLinkTTL)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("リンクの作成に失敗しました．\nもう一度送信してくださいm(__)m"))
+		return
+	}
+	urls := bot.exportURLs(token, time.Now().Add(ExportLinkTTL))
+	bot.ReplyMessage(ctx, event, ExportLinksMessage(len(list), urls))
+}
+
+// リンクの宛先のお気に入りを読み込む
+func (bot *Bot) exportList(ctx context.Context, target *LinkTarget) ([]FavoriteItem, error) {
+	if target.ListID != "" {
+		shared := SharedFavorite{}
+		if err := mystore.Get(ctx, bot.DatastoreClient, &shared, target.ListID, nil); err != nil {
+			return nil, err
+		}
+		return shared.List, nil
+	}
+	f, err := bot.getFavorite(ctx, target.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return f.List, nil
+}
+
+// 署名付きURLからお気に入りをダウンロードさせる
+func (bot *Bot) ExportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		params := r.URL.Query()
+
+		// 署名鍵がなければ署名を確かめられないので受け付けない
+		if len(bot.URLSigningKey) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		switch err := signedurl.Verify(bot.URLSigningKey, params, time.Now()); err {
+		case nil:
+		case signedurl.ErrExpired:
+			http.Error(w, "link expired", http.StatusGone)
+			return
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		format, err := export.ParseFormat(params.Get(exportParamFormat))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target, err := bot.lookupLink(ctx, params.Get(linkParamToken), LinkPurposeExport)
+		if err == datastore.ErrNoSuchEntity {
+			http.Error(w, "link expired", http.StatusGone)
+			return
+		} else if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		list, err := bot.exportList(ctx, target)
+		if err == datastore.ErrNoSuchEntity {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		filename := format.Filename("favorites-" + time.Now().In(jst).Format("20060102"))
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.Header().Set("Cache-Control", "no-store")
+		if err := export.Write(w, format, ExportRecords(list)); err != nil {
+			log.Print(err)
+		}
+	}
+}
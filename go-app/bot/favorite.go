@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// じぶん評価の最大
+const MaxMyRating = 5
+
+// よく使うタグ
+var presetTags = []string{"ランチ", "ディナー", "接待", "デート", "カフェ", "飲み会"}
+
+// お気に入りにメモやタグを送るときの接頭辞
+const (
+	MemoPrefix = "メモ:"
+	TagPrefix  = "タグ:"
+)
+
+// ErrFavoriteNotFound errors
+var ErrFavoriteNotFound = errors.New("favorite not found")
+
+// お気に入りの編集内容
+type FavoriteEditInfo struct {
+	PlaceID string `json:"place_id"`
+	ListID  string `json:"list_id,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Rating  int    `json:"rating,omitempty"`
+}
+
+func (e *FavoriteEditInfo) PostbackData() {}
+
+// ユーザのお気に入りを読み込む．
+// キーからはユーザIDがわからないので，閉業の通知に使うユーザIDがない古いリストには書き足しておく
+func (bot *Bot) getFavorite(ctx context.Context, userID string) (*Favorite, error) {
+	f := Favorite{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &f, userID, nil); err != nil {
+		return &f, err
+	}
+	if f.UserID == "" {
+		if err := bot.backfillFavoriteUserID(ctx, userID); err != nil {
+			log.Print(err)
+		}
+		f.UserID = userID
+	}
+	return &f, nil
+}
+
+// お気に入りにユーザIDを書き足す．同時に書き換えられても消さないようにトランザクションで更新する
+func (bot *Bot) backfillFavoriteUserID(ctx context.Context, userID string) error {
+	key := (&Favorite{}).NameKey(userID, nil)
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		f := Favorite{}
+		if err := tx.Get(key, &f); err != nil {
+			return err
+		}
+		if f.UserID != "" {
+			return nil
+		}
+		f.UserID = userID
+		_, err := tx.Put(key, &f)
+		return err
+	})
+	return err
+}
+
+// 編集するお気に入り(個人または共有リスト)のキー
+func (bot *Bot) favoriteListKey(ctx context.Context, scope Scope, listID string) (*datastore.Key, func() favoriteList, error) {
+	if listID == "" && !scope.IsGroup() {
+		key := (&Favorite{}).NameKey(scope.UserID, nil)
+		return key, func() favoriteList { return &Favorite{UserID: scope.UserID} }, nil
+	}
+	id, ok := bot.sharedListID(ctx, scope, listID)
+	if !ok {
+		return nil, nil, ErrFavoriteNotFound
+	}
+	key := (&SharedFavorite{}).NameKey(id, nil)
+	return key, func() favoriteList { return &SharedFavorite{} }, nil
+}
+
+// お気に入り(個人または共有リスト)のお店を読み込む
+func (bot *Bot) findFavoriteItem(ctx context.Context, scope Scope, listID, placeID string) (*FavoriteItem, error) {
+	key, newList, err := bot.favoriteListKey(ctx, scope, listID)
+	if err != nil {
+		return nil, err
+	}
+	l := newList()
+	if err := bot.DatastoreClient.Get(ctx, key, l); err != nil {
+		return nil, err
+	}
+	item := FindFavoriteItem(l.favoriteItems(), placeID)
+	if item == nil {
+		return nil, ErrFavoriteNotFound
+	}
+	return item, nil
+}
+
+// お気に入り(個人または共有リスト)のお店を書き換えて保存する．
+// 共有リストは複数人が同時に書き換えるので，変更を失わないようにトランザクションで更新する
+func (bot *Bot) updateFavoriteItem(ctx context.Context, scope Scope, listID, placeID string, update func(item *FavoriteItem)) (*FavoriteItem, error) {
+	key, newList, err := bot.favoriteListKey(ctx, scope, listID)
+	if err != nil {
+		return nil, err
+	}
+	var updated FavoriteItem
+	_, err = bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		l := newList()
+		if err := tx.Get(key, l); err != nil {
+			return err
+		}
+		item := FindFavoriteItem(l.favoriteItems(), placeID)
+		if item == nil {
+			return ErrFavoriteNotFound
+		}
+		update(item)
+		updated = *item
+		if f, ok := l.(*Favorite); ok {
+			f.UserID = scope.UserID
+		}
+		l.Touch(time.Now())
+		_, err := tx.Put(key, l)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (bot *Bot) replyFavoriteEditError(ctx context.Context, event *linebot.Event, err error) {
+	if err == ErrFavoriteNotFound {
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入りに見つかりませんでした"))
+		return
+	}
+	bot.ReplyMessage(ctx, event, TextMessage("お気に入りの編集に失敗しました..."))
+}
+
+// 編集メニューを表示して，テキストで送られるメモやタグの対象にする
+// (編集中のお店は発言したユーザごとに覚える)
+func (bot *Bot) EditFavorite(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	scope := NewScope(event.Source)
+	item, err := bot.findFavoriteItem(ctx, scope, info.ListID, info.PlaceID)
+	if err != nil {
+		bot.replyFavoriteEditError(ctx, event, err)
+		return
+	}
+	edit := FavoriteEdit{
+		ListID:  info.ListID,
+		PlaceID: info.PlaceID,
+	}
+	// グループのリストは1対1で続けて送られても個人のお気に入りと取り違えないようにIDで覚える
+	if edit.ListID == "" && scope.IsGroup() {
+		edit.ListID = scope.Key()
+	}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &edit, userID, nil); err != nil {
+		bot.replyFavoriteEditError(ctx, event, err)
+		return
+	}
+	bot.ReplyMessage(ctx, event, FavoriteEditQuickReply(item, info.ListID))
+}
+
+// タグをつけ外しする
+func (bot *Bot) ToggleFavoriteTag(ctx context.Context, event *linebot.Event, info *FavoriteEditInfo) {
+	scope := NewScope(event.Source)
+	item, err := bot.updateFavoriteItem(ctx, scope, info.ListID, info.PlaceID, func(item *FavoriteItem) {
+		item.ToggleTag(info.Tag)
+	})
+	if err != nil {
+		bot.replyFavoriteEditError(ctx, event, err)
+		return
+	}
+	bot.ReplyMessage(ctx, event, FavoriteEditQuickReply(item, info.ListID))
+}
+
+// じぶん評価の選択肢を表示する
+func (bot *Bot) ChooseFavoriteRating(ctx context.Context, event *linebot.Event, info *FavoriteEditInfo) {
+	bot.ReplyMessage(ctx, event, FavoriteRatingQuickReply(info))
+}
+
+// じぶん評価をつける
+func (bot *Bot) RateFavorite(ctx context.Context, event *linebot.Event, info *FavoriteEditInfo) {
+	scope := NewScope(event.Source)
+	if info.Rating < 0 || info.Rating > MaxMyRating {
+		text := fmt.Sprintf("評価は0〜%dで選んでください", MaxMyRating)
+		bot.ReplyMessage(ctx, event, TextMessage(text))
+		return
+	}
+	item, err := bot.updateFavoriteItem(ctx, scope, info.ListID, info.PlaceID, func(item *FavoriteItem) {
+		item.MyRating = info.Rating
+	})
+	if err != nil {
+		bot.replyFavoriteEditError(ctx, event, err)
+		return
+	}
+	bot.ReplyMessage(ctx, event, FavoriteEditQuickReply(item, info.ListID))
+}
+
+// テキストで送られたメモやタグを編集中のお店に保存する
+func (bot *Bot) EditFavoriteByText(ctx context.Context, event *linebot.Event, prefix, text string) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	scope := NewScope(event.Source)
+	edit := FavoriteEdit{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &edit, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入りの「タグ・メモ・評価」を選択してから送信してください"))
+		return
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		bot.ReplyMessage(ctx, event, TextMessage("「"+prefix+"内容」の形式で続けて送信してネ"))
+		return
+	}
+	item, err := bot.updateFavoriteItem(ctx, scope, edit.ListID, edit.PlaceID, func(item *FavoriteItem) {
+		switch prefix {
+		case MemoPrefix:
+			item.Memo = text
+		case TagPrefix:
+			if text != "" && !item.HasTag(text) {
+				item.Tags = append(item.Tags, text)
+			}
+		}
+	})
+	if err != nil {
+		bot.replyFavoriteEditError(ctx, event, err)
+		return
+	}
+	bot.ReplyMessage(ctx, event, FavoriteEditQuickReply(item, edit.ListID))
+}
+
+// お気に入りのメモ・タグ・評価の表示
+func favoriteSummary(item *FavoriteItem) string {
+	str := fmt.Sprintf("「%s」", item.Name)
+	if len(item.Tags) > 0 {
+		str += "\n#" + strings.Join(item.Tags, " #")
+	}
+	if item.MyRating > 0 {
+		str += fmt.Sprintf("\nじぶん評価: %d/%d", item.MyRating, MaxMyRating)
+	}
+	if item.Memo != "" {
+		str += "\nメモ: " + item.Memo
+	}
+	return str
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func TestRateFavoriteOutOfRange(t *testing.T) {
+	want := []string{fmt.Sprintf("評価は0〜%dで選んでください", MaxMyRating)}
+	for _, rating := range []int{-1, MaxMyRating + 1} {
+		bot, _, line := newTestBot(t)
+		info := FavoriteEditInfo{PlaceID: "p1", Rating: rating}
+		bot.RateFavorite(context.Background(), userEvent("U1", "rate"), &info)
+		if got := line.ReplyTexts("rate"); !reflect.DeepEqual(got, want) {
+			t.Errorf("rating %d: reply = %q, want %q", rating, got, want)
+		}
+	}
+}
+
+func TestEditFavoriteInGroup(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	scope := Scope{Type: ScopeTypeGroup, ID: "G1"}
+	shared := SharedFavorite{Name: "グループ", List: []FavoriteItem{
+		{Place: places.Place{PlaceID: "p1"}},
+		{Place: places.Place{PlaceID: "p2"}},
+	}}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &shared, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+	saved := func() SharedFavorite {
+		s := SharedFavorite{}
+		if err := mystore.Get(ctx, bot.DatastoreClient, &s, scope.Key(), nil); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// 編集メニューを開くだけではリストを書き換えない
+	before := saved().UpdatedAt
+	bot.EditFavorite(ctx, groupEvent("G1", "U1", "edit1"), &PlaceInfo{PlaceID: "p1"})
+	bot.EditFavorite(ctx, groupEvent("G1", "U2", "edit2"), &PlaceInfo{PlaceID: "p2"})
+	if after := saved().UpdatedAt; !after.Equal(before) {
+		t.Errorf("list is saved by opening the menu: %v -> %v", before, after)
+	}
+
+	// ユーザIDがなければ編集できない
+	bot.EditFavorite(ctx, groupEvent("G1", "", "anonymous"), &PlaceInfo{PlaceID: "p1"})
+	if got := line.ReplyTexts("anonymous"); !reflect.DeepEqual(got, []string{noUserIDText}) {
+		t.Errorf("reply = %q, want %q", got, noUserIDText)
+	}
+
+	// それぞれが編集中のお店にメモがつく
+	bot.EditFavoriteByText(ctx, groupEvent("G1", "U2", "memo2"), MemoPrefix, "U2のメモ")
+	bot.EditFavoriteByText(ctx, groupEvent("G1", "U1", "memo1"), MemoPrefix, "U1のメモ")
+	list := saved().List
+	if memo := FindFavoriteItem(list, "p1").Memo; memo != "U1のメモ" {
+		t.Errorf("memo of p1 = %q, want U1のメモ", memo)
+	}
+	if memo := FindFavoriteItem(list, "p2").Memo; memo != "U2のメモ" {
+		t.Errorf("memo of p2 = %q, want U2のメモ", memo)
+	}
+
+	// グループで開いた編集を1対1で続けても個人のお気に入りは変えない
+	bot.EditFavoriteByText(ctx, userEvent("U1", "personal"), MemoPrefix, "1対1のメモ")
+	if got := line.ReplyTexts("personal"); !reflect.DeepEqual(got, []string{"お気に入りに見つかりませんでした"}) {
+		t.Errorf("reply = %q, want not found", got)
+	}
+}
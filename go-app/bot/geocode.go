@@ -0,0 +1,38 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 地名の後ろにつけて位置検索を表す語
+var locationSuffixes = []string{"の周辺", "周辺", "の付近", "付近", "の近く", "近く", "のあたり", "あたり"}
+
+// 住所らしい文字列
+var addressPattern = regexp.MustCompile(`^(東京都|北海道|(京都|大阪)府|.{2,3}県).+|[0-9０-９]+丁目|[0-9０-９]+[-－ー][0-9０-９]+`)
+
+// テキストから検索地点の名前を取り出す
+func LocationName(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	for _, suffix := range locationSuffixes {
+		if strings.HasSuffix(text, suffix) {
+			name := strings.TrimSpace(strings.TrimSuffix(text, suffix))
+			return name, name != ""
+		}
+	}
+	if addressPattern.MatchString(text) {
+		return text, true
+	}
+	return "", false
+}
+
+// 住所の表示から国名と郵便番号を除く
+func shortAddress(address string) string {
+	address = strings.TrimPrefix(address, "日本、")
+	if strings.HasPrefix(address, "〒") {
+		if i := strings.Index(address, " "); i >= 0 {
+			address = address[i+1:]
+		}
+	}
+	return address
+}
@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+// 問い合わせを記録して決まった地点を返す
+type fakeGeocoder struct {
+	names []string
+}
+
+func (f *fakeGeocoder) Geocode(name string) (*places.GeocodeResult, error) {
+	f.names = append(f.names, name)
+	if name == "" {
+		return nil, errors.New("empty address")
+	}
+	return &places.GeocodeResult{Address: "日本、東京都千代田区丸の内1丁目", Location: places.LatLng{Lat: "35.681236", Lng: "139.767125"}}, nil
+}
+
+func TestLocationName(t *testing.T) {
+	tests := []struct {
+		text string
+		name string
+		ok   bool
+	}{
+		{"東京駅周辺", "東京駅", true},
+		{"渋谷の近く", "渋谷", true},
+		{"東京都千代田区丸の内1-9-1", "東京都千代田区丸の内1-9-1", true},
+		{"周辺", "", false},
+		{"ありがとう", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := LocationName(tt.text)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("LocationName(%q) = %q, %v, want %q, %v", tt.text, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestHandleFreeTextGeocoding(t *testing.T) {
+	tests := []struct {
+		text  string
+		names []string
+	}{
+		{"東京駅周辺", []string{"東京駅"}},
+		// 地名らしくない文は問い合わせない
+		{"ありがとう", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		bot, _, line := newTestBot(t)
+		geocoder := &fakeGeocoder{}
+		bot.Geocoder = geocoder
+		bot.HandleFreeText(context.Background(), userEvent("U1", "reply"), tt.text)
+		if !reflect.DeepEqual(geocoder.names, tt.names) {
+			t.Errorf("%q: geocoded %q, want %q", tt.text, geocoder.names, tt.names)
+		}
+		if len(line.Reply("reply")) == 0 {
+			t.Errorf("%q: no reply", tt.text)
+		}
+	}
+}
+
+func TestAddKeywordWithoutLocation(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	geocoder := &fakeGeocoder{}
+	bot.Geocoder = geocoder
+
+	bot.AddKeyword(ctx, userEvent("U1", "idle"), "ありがとう")
+	if len(geocoder.names) != 0 {
+		t.Errorf("geocoded %q for a keyword", geocoder.names)
+	}
+	if texts := line.ReplyTexts("idle"); len(texts) != 1 || !strings.Contains(texts[0], "位置情報を送信") {
+		t.Errorf("reply = %q, want the location hint", texts)
+	}
+
+	bot.AddKeyword(ctx, userEvent("U1", "place"), "東京駅周辺")
+	if !reflect.DeepEqual(geocoder.names, []string{"東京駅"}) {
+		t.Errorf("geocoded %q, want [東京駅]", geocoder.names)
+	}
+}
@@ -7,17 +7,29 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
-	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/recommend"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/richmenu"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
 const (
-	MaxPlaces int = 10
+	MaxPlaces         int = 10
+	MaxSavedLocations int = 10
+	// お気に入りは1エンティティに保存するので上限(1MB)に余裕を持たせる
+	MaxFavoriteBytes int = 900 * 1024
 )
 
+// 日時の表示に使うタイムゾーン
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// 登録地点の名前を送るときの接頭辞
+const LocationNamePrefix = "登録:"
+
 func (bot *Bot) CallbackHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := context.Background()
@@ -43,6 +55,10 @@ func (bot *Bot) LineEventsController(ctx context.Context, events []*linebot.Even
 			bot.HandleMessage(ctx, event)
 		case linebot.EventTypePostback:
 			bot.HandlePostback(ctx, event)
+		case linebot.EventTypeJoin:
+			bot.HandleJoin(ctx, event)
+		case linebot.EventTypeLeave:
+			bot.HandleLeave(ctx, event)
 		}
 	}
 }
@@ -58,53 +74,85 @@ func (bot *Bot) HandleMessage(ctx context.Context, event *linebot.Event) {
 
 func (bot *Bot) HandleTextMessage(ctx context.Context, event *linebot.Event) {
 	msg := event.Message.(*linebot.TextMessage)
-	text := msg.Text
-	switch text {
-	case "位置情報検索":
-		bot.ReplyMessage(ctx, event, LocationSendButton())
-	case "お気に入りを見る":
-		bot.ShowFavorite(ctx, event)
-	default:
-		bot.AddKeyword(ctx, event)
-	}
+	router.HandleText(bot, ctx, event, msg.Text)
 }
 
 // 返信
 func (bot *Bot) ReplyMessage(ctx context.Context, event *linebot.Event, messages ...linebot.SendingMessage) {
 	replyToken := event.ReplyToken
+	messages = bot.prepareMessages(ctx, messages)
 	if _, err := bot.LINEBotClient.ReplyMessage(replyToken, messages...).Do(); err != nil {
 		log.Print(err)
 	}
 }
 
+// プッシュ送信．ボタンのデータは返信と同じように保存する
+func (bot *Bot) PushMessage(ctx context.Context, to string, messages ...linebot.SendingMessage) error {
+	messages = bot.prepareMessages(ctx, messages)
+	_, err := bot.LINEBotClient.PushMessage(to, messages...).WithContext(ctx).Do()
+	return err
+}
+
+// 位置情報送信ボタン．登録地点があればクイックリプライで選べるようにする
+func (bot *Bot) ShowLocationSendButton(ctx context.Context, event *linebot.Event) {
+	userID, ok := NewScope(event.Source).PersonalKey()
+	l := SavedLocations{}
+	if !ok {
+		bot.ReplyMessage(ctx, event, LocationSendButton())
+		return
+	}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil); err != nil || len(l.List) == 0 {
+		bot.ReplyMessage(ctx, event, LocationSendButton())
+		return
+	}
+	bot.ReplyMessage(ctx, event, LocationSendButton().WithQuickReplies(SavedLocationsQuickReplyItems(l.List)))
+}
+
 // お気に入りを表示
-func (bot *Bot) ShowFavorite(ctx context.Context, event *linebot.Event) {
-	userID := event.Source.UserID
-	f := Favorite{}
-	err := mystore.Get(ctx, bot.DatastoreClient, &f, userID, nil)
+func (bot *Bot) ShowFavorite(ctx context.Context, event *linebot.Event, info *FavoriteListInfo) {
+	// グループではグループの共有リスト
+	if info.ListID != "" || NewScope(event.Source).IsGroup() {
+		bot.ShowSharedFavorite(ctx, event, info)
+		return
+	}
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	f, err := bot.getFavorite(ctx, userID)
 	if err == datastore.ErrNoSuchEntity || len(f.List) == 0 {
 		bot.ReplyMessage(ctx, event, TextMessage("お気に入りがありません"))
 		return
 	}
-	favoritePlaces := FavoritePlaces(f.List)
-	bot.ReplyMessage(ctx, event, CarouselMessage(&favoritePlaces, MaxPlaces))
+	list := FilterFavoriteItems(f.List, info.Tag)
+	if len(list) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("#"+info.Tag+" のお気に入りがありません"))
+		return
+	}
+	start, end, nav := Paginate(len(list), MaxPlaces-1, info)
+	favoritePlaces := FavoritePlaces(list[start:end])
+	carousel := PagedCarousel{Items: &favoritePlaces, Nav: nav}
+	bot.ReplyMessage(ctx, event, CarouselMessage(&carousel, MaxPlaces).WithQuickReplies(FavoriteListQuickReplyItems(f.List, info)))
 }
 
 // 検索クエリにキーワードを追加
-func (bot *Bot) AddKeyword(ctx context.Context, event *linebot.Event) {
-	userID := event.Source.UserID
+func (bot *Bot) AddKeyword(ctx context.Context, event *linebot.Event, keyword string) {
+	scopeKey := NewScope(event.Source).Key()
 	q := Query{}
-	if err := mystore.Get(ctx, bot.DatastoreClient, &q, userID, nil); err != nil {
+	if err := bot.getQuery(ctx, scopeKey, &q); err != nil {
+		// 位置情報がなければ「〜周辺」や住所のときだけ地名として検索する
+		if name, ok := LocationName(keyword); ok && bot.SearchByLocationName(ctx, event, name) {
+			return
+		}
 		bot.ReplyMessage(ctx, event, TextMessage("位置情報を送信して「キーワードで絞り込み」を選択してください"))
 		return
 	}
-	keyword := event.Message.(*linebot.TextMessage).Text
 	q.Keywords = append(q.Keywords, keyword)
-	if err := mystore.Save(ctx, bot.DatastoreClient, &q, userID, nil); err != nil {
+	if err := mystore.Save(ctx, bot.DatastoreClient, &q, scopeKey, nil); err != nil {
 		bot.ReplyMessage(ctx, event, TextMessage("キーワードの保存に失敗しました．\nもう一度送信してくださいm(__)m"))
 		return
 	}
-	bot.ReplyMessage(ctx, event, SearchConfirmWindow((*Query)(&q)))
+	bot.ReplyMessage(ctx, event, SearchConfirmWindow((*Query)(&q)).WithQuickReplies(KeywordQuickReplyItems(&q)))
 }
 
 func (bot *Bot) HandleLocationMessage(ctx context.Context, event *linebot.Event) {
@@ -117,7 +165,25 @@ func (bot *Bot) HandleLocationMessage(ctx context.Context, event *linebot.Event)
 		Radius:   "500",
 		Page:     0,
 	}
-	bot.ReplyMessage(ctx, event, SearchConfirmWindow(&q))
+	bot.replyWithNearFavorites(ctx, event, &q, SearchConfirmWindow(&q).WithQuickReplies(LocationQuickReplyItems(&q)))
+}
+
+// 地名や住所から位置を求めて検索確認ウィンドウを返す
+// 位置が見つからなければ何も返信せずにfalseを返す
+func (bot *Bot) SearchByLocationName(ctx context.Context, event *linebot.Event, name string) bool {
+	if strings.TrimSpace(name) == "" {
+		return false
+	}
+	result, err := bot.Geocoder.Geocode(name)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	q := NewQuery(result.Location.Lat, result.Location.Lng)
+	q.Address = shortAddress(result.Address)
+	text := fmt.Sprintf("「%s」周辺で検索します", q.Address)
+	bot.replyWithNearFavorites(ctx, event, &q, TextMessage(text), SearchConfirmWindow(&q).WithQuickReplies(LocationQuickReplyItems(&q)))
+	return true
 }
 
 func float64ToString(s float64) string {
@@ -130,44 +196,62 @@ func (bot *Bot) HandlePostback(ctx context.Context, event *linebot.Event) {
 		log.Print(err)
 		return
 	}
-
-	data := postback.Data
-	switch postback.Action {
-	case PostbackActionChangeRadius:
-		bot.ChangeRadius(ctx, event, data.(*Query))
-	case PostbackActionChangeKeyword:
-		bot.ChangeKeyword(ctx, event, data.(*Query))
-	case PostbackActionUpdateRadius:
-		bot.UpdateRadius(ctx, event, data.(*Query))
-	case PostbackActionNearbySearch:
-		bot.ShowNearbyPlaces(ctx, event, data.(*Query))
-	case PostbackActionAddFavorite:
-		bot.AddFavorite(ctx, event, data.(*PlaceInfo))
-	case PostbackActionDeleteFavorite:
-		bot.DeleteFavorite(ctx, event, data.(*PlaceInfo))
+	if postback.Token != "" {
+		if err := bot.loadPostbackState(ctx, &postback); err != nil {
+			if err != datastore.ErrNoSuchEntity {
+				log.Print(err)
+			}
+			bot.ReplyMessage(ctx, event, TextMessage(stalePostbackText))
+			return
+		}
 	}
+
+	router.HandlePostback(bot, ctx, event, &postback)
 }
 
 func (bot *Bot) ChangeRadius(ctx context.Context, event *linebot.Event, q *Query) {
-	userID := event.Source.UserID
-	if err := mystore.Save(ctx, bot.DatastoreClient, q, userID, nil); err != nil {
+	scope := NewScope(event.Source)
+	if err := mystore.Save(ctx, bot.DatastoreClient, q, scope.Key(), nil); err != nil {
 		return
 	}
-	bot.ReplyMessage(ctx, event, RadiusQuickReply(q))
+	bot.StartConversation(ctx, scope, StateAwaitingRadius)
+	bot.ReplyMessage(ctx, event, RadiusQuickReply(q, scope.IsGroup()))
 }
 func (bot *Bot) ChangeKeyword(ctx context.Context, event *linebot.Event, q *Query) {
-	userID := event.Source.UserID
-	q.Keywords = []string{}
-	if err := mystore.Save(ctx, bot.DatastoreClient, q, userID, nil); err != nil {
+	scope := NewScope(event.Source)
+	if err := mystore.Save(ctx, bot.DatastoreClient, q, scope.Key(), nil); err != nil {
 		return
 	}
-	bot.ReplyMessage(ctx, event, TextMessage("キーワードを入力してネ\n送ったメッセージの数だけキーワードが追加されます!"))
+	prefix := ""
+	if scope.IsGroup() {
+		prefix = GroupCommandPrefix
+	}
+	text := "キーワードを「" + prefix + "ラーメン」のように入力してネ\n送ったメッセージの数だけキーワードが追加されます!\n" +
+		"「" + prefix + ExcludePrefix + "辛い」で除外，「" + prefix + "ラーメン" + OrSeparator + "つけ麺」でどちらかを含むお店を探します"
+	if len(q.Keywords) > 0 {
+		text += "\n下のボタンでキーワードを削除できます"
+	}
+	text += "\n" + conversationHint(scope)
+	bot.StartConversation(ctx, scope, StateAwaitingKeyword)
+	bot.ReplyMessage(ctx, event, TextMessage(text).WithQuickReplies(KeywordQuickReplyItems(q)))
 }
 
 func (bot *Bot) UpdateRadius(ctx context.Context, event *linebot.Event, q *Query) {
 	bot.ReplyMessage(ctx, event, SearchConfirmWindow(q))
 }
 
+func (bot *Bot) ChangeTravel(ctx context.Context, event *linebot.Event, q *Query) {
+	scopeKey := NewScope(event.Source).Key()
+	if err := mystore.Save(ctx, bot.DatastoreClient, q, scopeKey, nil); err != nil {
+		return
+	}
+	bot.ReplyMessage(ctx, event, TravelQuickReply(q))
+}
+
+func (bot *Bot) UpdateTravel(ctx context.Context, event *linebot.Event, q *Query) {
+	bot.ReplyMessage(ctx, event, SearchConfirmWindow(q))
+}
+
 func (bot *Bot) ShowNearbyPlaces(ctx context.Context, event *linebot.Event, q *Query) {
 	p, err := bot.NearbySearch(q)
 	if err != nil {
@@ -175,14 +259,47 @@ func (bot *Bot) ShowNearbyPlaces(ctx context.Context, event *linebot.Event, q *Q
 		bot.ReplyMessage(ctx, event, TextMessage("検索に失敗しました..."))
 		return
 	}
+	// 移動時間が取れなくても検索結果は表示する
+	if err := bot.AddTravelTimes(q, *p); err != nil {
+		log.Print(err)
+	}
+	bot.SaveLastSearch(ctx, event, q)
+	bot.SaveSearchHistory(ctx, event, q, len(*p))
+	bot.SwitchRichMenu(ctx, event, richmenu.Default)
+	scope := NewScope(event.Source)
+	bot.MarkVisited(ctx, scope.UserID, *p)
+	group := scope.IsGroup()
+	// グループでは個人の好みを使わない
+	if !group {
+		if len(q.Keywords) > 0 {
+			bot.learnPreference(ctx, scope.UserID, func(pref *recommend.Preference) {
+				ks := ParseKeywords(q.Keywords)
+				pref.LearnKeywords(ks.Positive())
+			})
+		}
+		// 移動時間で並べ替えたときはその順番を優先する
+		if !q.SortByTravelTime {
+			bot.Recommend(ctx, scope.UserID, *p)
+		}
+	}
+	msgs := []linebot.SendingMessage{}
+	if text, ok := truncatedSearchText(q); ok {
+		msgs = append(msgs, TextMessage(text))
+	}
 	if len(*p) == 0 {
-		bot.ReplyMessage(ctx, event, TextMessage("見つかりませんでした(´・ω・`)"))
+		msgs = append(msgs, TextMessage("見つかりませんでした(´・ω・`)"))
 	} else {
-		bot.ReplyMessage(ctx, event, CarouselMessage((*NearbyPlaces)(p), MaxPlaces))
+		msgs = append(msgs, CarouselMessage((*NearbyPlaces)(p), MaxPlaces).WithQuickReplies(SearchResultQuickReplyItems(q, *p, group)))
 	}
+	bot.ReplyMessage(ctx, event, msgs...)
 }
 
 func (bot *Bot) AddFavorite(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
+	// グループや共有リストを指定されたときは共有リストに追加
+	if info.ListID != "" || NewScope(event.Source).IsGroup() {
+		bot.AddSharedFavorite(ctx, event, info)
+		return
+	}
 	placeID := info.PlaceID
 	p, err := bot.DetailsSearch(placeID)
 	if err != nil {
@@ -191,14 +308,17 @@ func (bot *Bot) AddFavorite(ctx context.Context, event *linebot.Event, info *Pla
 		return
 	}
 
-	userID := event.Source.UserID
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
 
 	// Datastoreからリストを取得してお気に入り追加
 	f := Favorite{}
 	err = mystore.Get(ctx, bot.DatastoreClient, &f, userID, nil)
 	if err == datastore.ErrNoSuchEntity {
 		// エンティティがなければ作成
-		f.List = []places.Place{}
+		f.List = []FavoriteItem{}
 	} else if err != nil {
 		bot.ReplyMessage(ctx, event, TextMessage("お気に入り登録に失敗しました..."))
 		return
@@ -211,25 +331,46 @@ func (bot *Bot) AddFavorite(ctx context.Context, event *linebot.Event, info *Pla
 			return
 		}
 	}
-	if len(f.List) == MaxPlaces {
-		text := fmt.Sprintf("お気に入りに登録できるのは最大%d件です", MaxPlaces)
-		bot.ReplyMessage(ctx, event, TextMessage(text))
-		return
-	}
 	// 検索結果表示に使ったものと同じ画像
 	p.PhotoURI = info.PhotoURI
-	f.List = append(f.List, *p)
+	f.List = append(f.List, FavoriteItem{Place: *p})
+	size, err := mystore.Size(&f)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入り登録に失敗しました..."))
+		return
+	}
+	if size > MaxFavoriteBytes {
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入りがいっぱいです\nいくつか削除してから登録してください"))
+		return
+	}
+	f.UserID = userID
 	if err := mystore.Save(ctx, bot.DatastoreClient, &f, userID, nil); err != nil {
 		bot.ReplyMessage(ctx, event, TextMessage("お気に入り登録に失敗しました..."))
 		return
 	}
 
-	text := fmt.Sprintf("お気に入りに登録しました! (%d/%d)", len(f.List), MaxPlaces)
+	bot.learnPreference(ctx, userID, func(pref *recommend.Preference) {
+		pref.LearnPlace(p, favoriteLearnWeight)
+	})
+
+	text := fmt.Sprintf("お気に入りに登録しました! (%d件)", len(f.List))
+	if items := bot.sharedListQuickReplyItems(ctx, userID, info); items != nil {
+		bot.ReplyMessage(ctx, event, TextMessage(text).WithQuickReplies(items))
+		return
+	}
 	bot.ReplyMessage(ctx, event, TextMessage(text))
 }
 
 func (bot *Bot) DeleteFavorite(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
-	userID := event.Source.UserID
+	if info.ListID != "" || NewScope(event.Source).IsGroup() {
+		bot.DeleteSharedFavorite(ctx, event, info)
+		return
+	}
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
 	// お気に入りリストを取得
 	f := Favorite{}
 	err := mystore.Get(ctx, bot.DatastoreClient, &f, userID, nil)
@@ -239,7 +380,7 @@ func (bot *Bot) DeleteFavorite(ctx context.Context, event *linebot.Event, info *
 	}
 	// 削除操作後の新たなリスト
 	placeID := info.PlaceID
-	newList := []places.Place{}
+	newList := []FavoriteItem{}
 	had := false
 	for _, place := range f.List {
 		if placeID == place.PlaceID {
@@ -253,9 +394,194 @@ func (bot *Bot) DeleteFavorite(ctx context.Context, event *linebot.Event, info *
 		return
 	}
 	f.List = newList
+	f.UserID = userID
 	if err := mystore.Save(ctx, bot.DatastoreClient, &f, userID, nil); err != nil {
 		bot.ReplyMessage(ctx, event, TextMessage("お気に入り削除に失敗しました..."))
 		return
 	}
 	bot.ReplyMessage(ctx, event, TextMessage("お気に入り登録から削除しました!"))
 }
+
+// 登録地点の一覧
+func (bot *Bot) ShowSavedLocations(ctx context.Context, event *linebot.Event) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l := SavedLocations{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil)
+	if err == datastore.ErrNoSuchEntity || len(l.List) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("登録地点がありません\n位置情報を送信して「この場所を登録」を選択してください"))
+		return
+	}
+	text := "登録地点"
+	for _, location := range l.List {
+		text += "\n・" + location.Name
+	}
+	bot.ReplyMessage(ctx, event, TextMessage(text).WithQuickReplies(SavedLocationsQuickReplyItems(l.List)))
+}
+
+func (bot *Bot) ShowDeleteSavedLocations(ctx context.Context, event *linebot.Event) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l := SavedLocations{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil)
+	if err == datastore.ErrNoSuchEntity || len(l.List) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("登録地点がありません"))
+		return
+	}
+	bot.ReplyMessage(ctx, event, DeleteSavedLocationQuickReply(l.List))
+}
+
+// 送信された位置を名前の入力待ちとして保存
+func (bot *Bot) RegisterLocation(ctx context.Context, event *linebot.Event, q *Query) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l := SavedLocations{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		bot.ReplyMessage(ctx, event, TextMessage("地点の登録に失敗しました..."))
+		return
+	}
+	l.Pending = SavedLocation{
+		Lat:     q.Lat,
+		Lng:     q.Lng,
+		Address: q.Address,
+	}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("地点の登録に失敗しました..."))
+		return
+	}
+	bot.StartConversation(ctx, NewScope(event.Source), StateAwaitingLocationName)
+	bot.ReplyMessage(ctx, event, LocationNameQuickReply())
+}
+
+// 入力待ちの地点に名前をつけて登録
+func (bot *Bot) RegisterLocationName(ctx context.Context, event *linebot.Event, name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		bot.ReplyMessage(ctx, event, TextMessage("登録名を入力してください"))
+		return
+	}
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l := SavedLocations{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil); err != nil || l.Pending.Lat == "" {
+		bot.ReplyMessage(ctx, event, TextMessage("位置情報を送信して「この場所を登録」を選択してください"))
+		return
+	}
+	if _, ok := l.Find(name); !ok && len(l.List) >= MaxSavedLocations {
+		text := fmt.Sprintf("登録できる地点は最大%d件です", MaxSavedLocations)
+		bot.ReplyMessage(ctx, event, TextMessage(text))
+		return
+	}
+	location := l.Pending
+	location.Name = name
+	l.Put(location)
+	l.Pending = SavedLocation{}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("地点の登録に失敗しました..."))
+		return
+	}
+	bot.EndConversation(ctx, NewScope(event.Source))
+	text := fmt.Sprintf("「%s」を登録しました!\n「登録地点」と送信するとここから検索できます", name)
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+func (bot *Bot) UseSavedLocation(ctx context.Context, event *linebot.Event, q *Query) {
+	text := fmt.Sprintf("「%s」周辺で検索します", q.Address)
+	bot.SwitchRichMenu(ctx, event, richmenu.Searching)
+	bot.ReplyMessage(ctx, event, TextMessage(text), SearchConfirmWindow(q))
+}
+
+func (bot *Bot) DeleteSavedLocation(ctx context.Context, event *linebot.Event, info *LocationInfo) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l := SavedLocations{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("地点の削除に失敗しました..."))
+		return
+	}
+	if !l.Remove(info.Name) {
+		bot.ReplyMessage(ctx, event, TextMessage("すでに削除されています"))
+		return
+	}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("地点の削除に失敗しました..."))
+		return
+	}
+	text := fmt.Sprintf("「%s」を削除しました!", info.Name)
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+// 実行した検索を保存
+func (bot *Bot) SaveLastSearch(ctx context.Context, event *linebot.Event, q *Query) {
+	scopeKey := NewScope(event.Source).Key()
+	last := LastSearch{
+		Query:      *q,
+		SearchedAt: time.Now(),
+	}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &last, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// 前回の条件で検索確認ウィンドウを表示
+func (bot *Bot) ShowLastSearch(ctx context.Context, event *linebot.Event) {
+	scopeKey := NewScope(event.Source).Key()
+	last := LastSearch{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &last, scopeKey, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("前回の検索条件がありません"))
+		return
+	}
+	text := fmt.Sprintf("前回(%s)の条件です\n現在地で検索するときは位置情報を送信して「前回の条件を使う」を選択してください", last.SearchedAt.In(jst).Format("1/2 15:04"))
+	bot.ReplyMessage(ctx, event, TextMessage(text), SearchConfirmWindow(&last.Query).WithQuickReplies(LastSearchQuickReplyItems()))
+}
+
+// 前回の条件を新しい地点に適用
+func (bot *Bot) ApplyLastSearch(ctx context.Context, event *linebot.Event, q *Query) {
+	scopeKey := NewScope(event.Source).Key()
+	last := LastSearch{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &last, scopeKey, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("前回の検索条件がありません"))
+		return
+	}
+	last.ApplyTo(q)
+	bot.ReplyMessage(ctx, event, SearchConfirmWindow(q))
+}
+
+// グループやトークルームに招待されたとき
+func (bot *Bot) HandleJoin(ctx context.Context, event *linebot.Event) {
+	text := "招待ありがとうございます!\n" +
+		"位置情報を送信するか「" + GroupCommandPrefix + "渋谷駅周辺」のように送るとお店を探します\n" +
+		"キーワードなどは「" + GroupCommandPrefix + "」をつけて送信してネ"
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+// グループやトークルームから退出したとき，その会話の状態を削除する
+func (bot *Bot) HandleLeave(ctx context.Context, event *linebot.Event) {
+	scopeKey := NewScope(event.Source).Key()
+	if err := mystore.Delete(ctx, bot.DatastoreClient, &Query{}, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+	if err := mystore.Delete(ctx, bot.DatastoreClient, &LastSearch{}, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+	if err := mystore.Delete(ctx, bot.DatastoreClient, &Poll{}, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+	if err := mystore.Delete(ctx, bot.DatastoreClient, &SearchHistory{}, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+	if err := mystore.Delete(ctx, bot.DatastoreClient, &Conversation{}, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+}
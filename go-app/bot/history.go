@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	// 保存する検索履歴の最大件数
+	MaxSearchHistory int = 20
+	// 「検索履歴」で表示する件数
+	SearchHistoryCount int = 10
+)
+
+// 実行した検索
+type SearchRecord struct {
+	Query       Query     `datastore:"query,noindex"`
+	SearchedAt  time.Time `datastore:"searched_at,noindex"`
+	ResultCount int       `datastore:"result_count,noindex"`
+}
+
+// 検索履歴の識別子
+func (r *SearchRecord) ID() int64 {
+	return r.SearchedAt.UnixNano()
+}
+
+// 検索履歴(新しい順)
+type SearchHistory struct {
+	Records []SearchRecord `datastore:"records,noindex"`
+	mystore.Timestamp
+}
+
+func (h *SearchHistory) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("SearchHistory", name, parent)
+}
+
+// 検索を記録する．古い記録は捨てる
+func (h *SearchHistory) Add(r SearchRecord) {
+	h.Records = append([]SearchRecord{r}, h.Records...)
+	if len(h.Records) > MaxSearchHistory {
+		h.Records = h.Records[:MaxSearchHistory]
+	}
+}
+
+// 識別子で検索履歴を探す
+func (h *SearchHistory) Find(id int64) *SearchRecord {
+	for i := range h.Records {
+		if h.Records[i].ID() == id {
+			return &h.Records[i]
+		}
+	}
+	return nil
+}
+
+// 検索履歴の操作
+type SearchHistoryInfo struct {
+	ID int64 `json:"id"`
+}
+
+func (s *SearchHistoryInfo) PostbackData() {}
+
+func (bot *Bot) getSearchHistory(ctx context.Context, scopeKey string) (*SearchHistory, error) {
+	h := SearchHistory{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &h, scopeKey, nil)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// 実行した検索を履歴に追加する
+func (bot *Bot) SaveSearchHistory(ctx context.Context, event *linebot.Event, q *Query, resultCount int) {
+	scopeKey := NewScope(event.Source).Key()
+	h, err := bot.getSearchHistory(ctx, scopeKey)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	h.Add(SearchRecord{
+		Query: *q,
+		// Datastoreはマイクロ秒までしか保存しないので識別子が変わらないように丸める
+		SearchedAt:  time.Now().Truncate(time.Microsecond),
+		ResultCount: resultCount,
+	})
+	if err := mystore.Save(ctx, bot.DatastoreClient, h, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// 検索履歴を表示
+func (bot *Bot) ShowSearchHistory(ctx context.Context, event *linebot.Event) {
+	scopeKey := NewScope(event.Source).Key()
+	h, err := bot.getSearchHistory(ctx, scopeKey)
+	if err != nil || len(h.Records) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("検索履歴がありません"))
+		return
+	}
+	records := SearchRecords(h.Records)
+	bot.ReplyMessage(ctx, event, CarouselMessage(&records, SearchHistoryCount))
+}
+
+// 履歴の条件でもう一度検索する
+func (bot *Bot) ReplaySearch(ctx context.Context, event *linebot.Event, info *SearchHistoryInfo) {
+	scopeKey := NewScope(event.Source).Key()
+	h, err := bot.getSearchHistory(ctx, scopeKey)
+	if err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("検索に失敗しました..."))
+		return
+	}
+	r := h.Find(info.ID)
+	if r == nil {
+		bot.ReplyMessage(ctx, event, TextMessage("検索履歴が見つかりませんでした"))
+		return
+	}
+	q := r.Query
+	// 続けてキーワードや距離を変えられるようにする
+	if err := mystore.Save(ctx, bot.DatastoreClient, &q, scopeKey, nil); err != nil {
+		log.Print(err)
+	}
+	bot.ShowNearbyPlaces(ctx, event, &q)
+}
@@ -0,0 +1,306 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/signedurl"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/takeout"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	// インポートのパス
+	ImportPath = "/import"
+	// アップロード画面のリンクの有効期間
+	ImportLinkTTL = 30 * time.Minute
+	// 1回で取り込むお店の最大件数
+	MaxImportEntries int = 100
+	// アップロードできるファイルの最大サイズ
+	MaxImportFileBytes int64 = 5 << 20
+	// お店の検索を並行して行う数
+	importWorkers = 4
+	// 取り込み全体と，そのうちお店の検索にかける時間の上限
+	ImportTimeout        = 60 * time.Second
+	importResolveTimeout = 45 * time.Second
+)
+
+// インポートの結果
+type ImportResult struct {
+	Imported  []string
+	Skipped   []string
+	Unmatched []string
+}
+
+// 結果のテキスト
+func (r *ImportResult) Summary() string {
+	str := fmt.Sprintf("インポートが完了しました\n追加: %d件\nスキップ: %d件\n見つからない: %d件",
+		len(r.Imported), len(r.Skipped), len(r.Unmatched))
+	if len(r.Unmatched) > 0 {
+		n := len(r.Unmatched)
+		if n > 5 {
+			n = 5
+		}
+		str += "\n\n見つからなかったお店:\n" + strings.Join(r.Unmatched[:n], "\n")
+		if len(r.Unmatched) > n {
+			str += fmt.Sprintf("\nほか%d件", len(r.Unmatched)-n)
+		}
+	}
+	return str
+}
+
+// インポート画面へのリンクを送る
+func (bot *Bot) ShowImportLink(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	if !bot.signedLinksEnabled() {
+		bot.ReplyMessage(ctx, event, TextMessage("インポートは利用できません"))
+		return
+	}
+	token, err := bot.issueLink(ctx, &LinkTarget{Purpose: LinkPurposeImport, UserID: scope.UserID}, ImportLinkTTL)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("リンクの作成に失敗しました．\nもう一度送信してくださいm(__)m"))
+		return
+	}
+	params := url.Values{linkParamToken: {token}}
+	uri := signedurl.URL(bot.URLSigningKey, bot.BaseURL, ImportPath, params, time.Now().Add(ImportLinkTTL))
+	bot.ReplyMessage(ctx, event, ImportLinkMessage(uri))
+}
+
+// お店を特定する．place IDがなければ名前と住所で探す
+func (bot *Bot) resolveImportEntry(e *takeout.Entry) (*places.Place, error) {
+	placeID := e.PlaceID
+	if placeID == "" {
+		var bias *places.LatLng
+		if e.HasLocation() {
+			bias = &places.LatLng{Lat: e.Lat, Lng: e.Lng}
+		}
+		id, err := bot.PlaceFinder.FindPlace(e.Query(), bias)
+		if err != nil {
+			return nil, err
+		}
+		placeID = id
+	}
+	p, err := bot.DetailsSearch(placeID)
+	if err != nil {
+		return nil, err
+	}
+	if p.PhotoURI == "" {
+		p.PhotoURI = places.AlternativePhotoURI()
+	}
+	return p, nil
+}
+
+// 読み込んだお店をユーザのお気に入りに追加する．
+// お店の検索には時間がかかるので，先に検索してから追加だけをトランザクション内で行う
+func (bot *Bot) ImportFavorites(ctx context.Context, userID string, entries []takeout.Entry) (*ImportResult, error) {
+	var over []takeout.Entry
+	if len(entries) > MaxImportEntries {
+		over = entries[MaxImportEntries:]
+		entries = entries[:MaxImportEntries]
+	}
+	resolved := bot.resolveImportEntries(ctx, entries)
+
+	var result ImportResult
+	key := (&Favorite{}).NameKey(userID, nil)
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		// 再試行されたときのために数え直す
+		result = ImportResult{}
+		f := Favorite{}
+		if err := tx.Get(key, &f); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		for i, e := range entries {
+			p := resolved[i]
+			if p == nil {
+				result.Unmatched = append(result.Unmatched, e.Query())
+				continue
+			}
+			if FindFavoriteItem(f.List, p.PlaceID) != nil {
+				result.Skipped = append(result.Skipped, p.Name+"(登録済み)")
+				continue
+			}
+			item := FavoriteItem{Place: *p, Tags: e.Tags, Memo: e.Note}
+			if e.MyRating > 0 && e.MyRating <= MaxMyRating {
+				item.MyRating = e.MyRating
+			}
+			f.List = append(f.List, item)
+			size, err := mystore.Size(&f)
+			if err != nil {
+				return err
+			}
+			if size > MaxFavoriteBytes {
+				f.List = f.List[:len(f.List)-1]
+				result.Skipped = append(result.Skipped, p.Name+"(容量超過)")
+				continue
+			}
+			result.Imported = append(result.Imported, p.Name)
+		}
+		if len(result.Imported) == 0 {
+			return nil
+		}
+		f.UserID = userID
+		f.Touch(time.Now())
+		_, err := tx.Put(key, &f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range over {
+		result.Skipped = append(result.Skipped, e.Name+"(件数上限)")
+	}
+	return &result, nil
+}
+
+// お店を並行して検索する．見つからなかったお店や時間内に検索できなかったお店はnil
+func (bot *Bot) resolveImportEntries(ctx context.Context, entries []takeout.Entry) []*places.Place {
+	ctx, cancel := context.WithTimeout(ctx, importResolveTimeout)
+	defer cancel()
+	resolved := make([]*places.Place, len(entries))
+	sem := make(chan struct{}, importWorkers)
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			p, err := bot.resolveImportEntry(&entries[i])
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			resolved[i] = p
+		}(i)
+	}
+	wg.Wait()
+	return resolved
+}
+
+var importPage = template.Must(template.New("import").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>お気に入りのインポート</title>
+</head>
+<body>
+<h1>お気に入りのインポート</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{with .Result}}
+<p>追加: {{len .Imported}}件 / スキップ: {{len .Skipped}}件 / 見つからない: {{len .Unmatched}}件</p>
+{{if .Imported}}<h2>追加したお店</h2><ul>{{range .Imported}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Skipped}}<h2>スキップしたお店</h2><ul>{{range .Skipped}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Unmatched}}<h2>見つからなかったお店</h2><ul>{{range .Unmatched}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{else}}
+<p>Googleマップの保存済みの場所(Takeoutの「保存した場所.json」やCSV)を選択してください．1回で{{.Max}}件まで取り込めます．</p>
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" accept=".json,.geojson,.csv" required>
+<button type="submit">インポート</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type importPageData struct {
+	Error  string
+	Result *ImportResult
+	Max    int
+}
+
+func renderImportPage(w http.ResponseWriter, status int, data importPageData) {
+	data.Max = MaxImportEntries
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := importPage.Execute(w, data); err != nil {
+		log.Print(err)
+	}
+}
+
+// 署名付きURLでファイルを受け取ってお気に入りに取り込む
+func (bot *Bot) ImportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), ImportTimeout)
+		defer cancel()
+		params := r.URL.Query()
+
+		// 署名鍵がなければ署名を確かめられないので受け付けない
+		if len(bot.URLSigningKey) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		switch err := signedurl.Verify(bot.URLSigningKey, params, time.Now()); err {
+		case nil:
+		case signedurl.ErrExpired:
+			renderImportPage(w, http.StatusGone, importPageData{Error: "リンクの有効期限が切れています．もう一度「インポート」と送信してください"})
+			return
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		target, err := bot.lookupLink(ctx, params.Get(linkParamToken), LinkPurposeImport)
+		if err == datastore.ErrNoSuchEntity {
+			renderImportPage(w, http.StatusGone, importPageData{Error: "リンクの有効期限が切れています．もう一度「インポート」と送信してください"})
+			return
+		} else if err != nil {
+			log.Print(err)
+			renderImportPage(w, http.StatusInternalServerError, importPageData{Error: "インポートに失敗しました"})
+			return
+		}
+		userID := target.UserID
+
+		switch r.Method {
+		case http.MethodGet:
+			renderImportPage(w, http.StatusOK, importPageData{})
+			return
+		case http.MethodPost:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxImportFileBytes)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			renderImportPage(w, http.StatusBadRequest, importPageData{Error: "ファイルを読み込めませんでした"})
+			return
+		}
+		defer file.Close()
+		entries, err := takeout.Parse(header.Filename, file)
+		if err != nil {
+			log.Print(err)
+			renderImportPage(w, http.StatusBadRequest, importPageData{Error: "対応していない形式か，お店が含まれていないファイルです"})
+			return
+		}
+		result, err := bot.ImportFavorites(ctx, userID, entries)
+		if err != nil {
+			log.Print(err)
+			renderImportPage(w, http.StatusInternalServerError, importPageData{Error: "インポートに失敗しました"})
+			return
+		}
+		if err := bot.PushMessage(ctx, userID, TextMessage(result.Summary())); err != nil {
+			log.Print(err)
+		}
+		renderImportPage(w, http.StatusOK, importPageData{Result: result})
+	}
+}
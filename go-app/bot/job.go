@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// 定期実行ジョブのリクエストか確かめる．
+// Cloud Schedulerから Authorization: Bearer <JOB_TOKEN> をつけて呼び出す
+func (bot *Bot) authorizeJob(r *http.Request) bool {
+	if bot.JobToken == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(bot.JobToken)) == 1
+}
+
+// 定期実行ジョブのリクエストを受け付けるか．POST以外や認証できないリクエストにはエラーを返す
+func (bot *Bot) acceptJob(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !bot.authorizeJob(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/nlquery"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 除外キーワードの接頭辞と，いずれかに一致させるキーワードの区切り
+const (
+	ExcludePrefix = "-"
+	OrSeparator   = "|"
+)
+
+// 1回の検索で並行して問い合わせる最大数
+const MaxParallelSearches int = 5
+
+// 全角で入力されても同じように扱う
+var keywordReplacer = strings.NewReplacer("－", ExcludePrefix, "−", ExcludePrefix, "｜", OrSeparator)
+
+// 検索キーワードを種類ごとに分けたもの
+type KeywordSet struct {
+	// すべてに一致させる
+	Include []string
+	// 名前や種類に含むお店を除く
+	Exclude []string
+	// それぞれのグループのいずれかに一致させる
+	Any [][]string
+}
+
+// キーワードを分類する
+func ParseKeywords(keywords []string) KeywordSet {
+	ks := KeywordSet{}
+	for _, k := range keywords {
+		k = strings.TrimSpace(keywordReplacer.Replace(k))
+		switch {
+		case strings.HasPrefix(k, ExcludePrefix):
+			if k = strings.TrimSpace(strings.TrimPrefix(k, ExcludePrefix)); k != "" {
+				ks.Exclude = append(ks.Exclude, k)
+			}
+		case strings.Contains(k, OrSeparator):
+			group := []string{}
+			for _, alt := range strings.Split(k, OrSeparator) {
+				if alt = strings.TrimSpace(alt); alt != "" {
+					group = append(group, alt)
+				}
+			}
+			if len(group) > 0 {
+				ks.Any = append(ks.Any, group)
+			}
+		case k != "":
+			ks.Include = append(ks.Include, k)
+		}
+	}
+	return ks
+}
+
+// 検索ごとのキーワード．いずれかのグループの組み合わせの数だけ検索する．
+// 組み合わせが MaxParallelSearches を超えたときは超えた分を検索せず，truncated を返す
+func (ks *KeywordSet) Searches() (searches [][]string, truncated bool) {
+	searches = [][]string{ks.Include}
+	for _, group := range ks.Any {
+		next := [][]string{}
+		for _, s := range searches {
+			for _, alt := range group {
+				if len(next) >= MaxParallelSearches {
+					truncated = true
+					break
+				}
+				next = append(next, append(append([]string{}, s...), alt))
+			}
+		}
+		searches = next
+	}
+	return searches, truncated
+}
+
+// 組み合わせが多すぎて検索しなかったキーワードがあることを伝える文
+func truncatedSearchText(q *Query) (string, bool) {
+	ks := ParseKeywords(q.Keywords)
+	if _, truncated := ks.Searches(); !truncated {
+		return "", false
+	}
+	return fmt.Sprintf("「%s」の組み合わせが多いため，最初の%d通りだけ検索しました", OrSeparator, MaxParallelSearches), true
+}
+
+// 好みの学習に使うキーワード(除外以外)
+func (ks *KeywordSet) Positive() []string {
+	keywords := append([]string{}, ks.Include...)
+	for _, group := range ks.Any {
+		keywords = append(keywords, group...)
+	}
+	return keywords
+}
+
+// 除外キーワードに当てはまるお店か．
+// 「-カフェ」のような種類を表す語は Places API の type に直して比べる
+func (ks *KeywordSet) Excluded(p *places.Place) bool {
+	name := strings.ToLower(p.Name)
+	for _, k := range ks.Exclude {
+		k = strings.ToLower(k)
+		if strings.Contains(name, k) {
+			return true
+		}
+		typ, ok := nlquery.TypeOf(k)
+		if !ok {
+			typ = k
+		}
+		for _, t := range p.Types {
+			if t == typ {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 除外キーワードに当てはまるお店を除く
+func (ks *KeywordSet) Filter(p places.Places) places.Places {
+	if len(ks.Exclude) == 0 {
+		return p
+	}
+	filtered := places.Places{}
+	for i := range p {
+		if !ks.Excluded(&p[i]) {
+			filtered = append(filtered, p[i])
+		}
+	}
+	return filtered
+}
+
+// 複数の検索結果を関連度の順番を保つように交互に並べて重複を除く
+func mergePlaces(results []places.Places) places.Places {
+	merged := places.Places{}
+	seen := map[string]bool{}
+	for i := 0; ; i++ {
+		added := false
+		for _, r := range results {
+			if i >= len(r) {
+				continue
+			}
+			added = true
+			if !seen[r[i].PlaceID] {
+				seen[r[i].PlaceID] = true
+				merged = append(merged, r[i])
+			}
+		}
+		if !added {
+			return merged
+		}
+	}
+}
+
+// キーワードの削除
+type KeywordInfo struct {
+	Keyword string `json:"keyword,omitempty"`
+	// すべて削除する
+	All bool `json:"all,omitempty"`
+}
+
+func (k *KeywordInfo) PostbackData() {}
+
+// キーワードを1つ(またはすべて)削除する
+func (bot *Bot) RemoveKeyword(ctx context.Context, event *linebot.Event, info *KeywordInfo) {
+	scopeKey := NewScope(event.Source).Key()
+	q := Query{}
+	if err := bot.getQuery(ctx, scopeKey, &q); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("位置情報を送信してから操作してください"))
+		return
+	}
+	keywords := []string{}
+	for _, k := range q.Keywords {
+		if !info.All && k != info.Keyword {
+			keywords = append(keywords, k)
+		}
+	}
+	q.Keywords = keywords
+	if err := mystore.Save(ctx, bot.DatastoreClient, &q, scopeKey, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("キーワードの保存に失敗しました．\nもう一度操作してくださいm(__)m"))
+		return
+	}
+	bot.ReplyMessage(ctx, event, SearchConfirmWindow(&q).WithQuickReplies(KeywordQuickReplyItems(&q)))
+}
@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func TestExcluded(t *testing.T) {
+	cafe := places.Place{Name: "珈琲館", Types: []string{"cafe", "food"}}
+	tests := []struct {
+		keywords []string
+		want     bool
+	}{
+		// 種類を表す語は type に直して比べる
+		{[]string{"-カフェ"}, true},
+		{[]string{"－喫茶"}, true},
+		{[]string{"-cafe"}, true},
+		{[]string{"-珈琲"}, true},
+		{[]string{"-バー"}, false},
+		{[]string{"カフェ"}, false},
+	}
+	for _, tt := range tests {
+		ks := ParseKeywords(tt.keywords)
+		if got := ks.Excluded(&cafe); got != tt.want {
+			t.Errorf("Excluded(%q) = %v, want %v", tt.keywords, got, tt.want)
+		}
+	}
+}
+
+func TestSearches(t *testing.T) {
+	ks := ParseKeywords([]string{"ランチ", "ラーメン|うどん"})
+	searches, truncated := ks.Searches()
+	want := [][]string{{"ランチ", "ラーメン"}, {"ランチ", "うどん"}}
+	if !reflect.DeepEqual(searches, want) || truncated {
+		t.Errorf("Searches() = %q, %v, want %q, false", searches, truncated, want)
+	}
+
+	// 上限を超える組み合わせは検索しない
+	ks = ParseKeywords([]string{"a|b|c", "d|e"})
+	searches, truncated = ks.Searches()
+	if len(searches) != MaxParallelSearches || !truncated {
+		t.Errorf("Searches() = %q, %v, want %d searches and truncated", searches, truncated, MaxParallelSearches)
+	}
+	if _, ok := truncatedSearchText(&Query{Keywords: []string{"a|b|c", "d|e"}}); !ok {
+		t.Error("truncatedSearchText() = false, want the notice")
+	}
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+)
+
+// 署名付きURLの用途
+type LinkPurpose string
+
+const (
+	LinkPurposeExport LinkPurpose = "export"
+	LinkPurposeImport LinkPurpose = "import"
+)
+
+// 署名付きURLのパラメータ．ユーザIDなどはURLに載せず，サーバに保存した宛先を指すトークンだけを載せる
+const linkParamToken = "t"
+
+// 署名付きURLが指す宛先
+type LinkTarget struct {
+	Purpose LinkPurpose `datastore:"purpose,noindex"`
+	// 個人のお気に入りならユーザID，共有リストならリストID
+	UserID    string    `datastore:"user_id,noindex"`
+	ListID    string    `datastore:"list_id,noindex"`
+	ExpiresAt time.Time `datastore:"expires_at"`
+	mystore.Timestamp
+}
+
+// トークンはハッシュにして保存する
+func (l *LinkTarget) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("LinkTarget", name, parent)
+}
+
+// 期限を過ぎたか
+func (l *LinkTarget) Expired(now time.Time) bool {
+	return now.After(l.ExpiresAt)
+}
+
+// 署名付きURLを発行できるか．署名鍵が空だと誰でも署名を作れるので発行しない
+func (bot *Bot) signedLinksEnabled() bool {
+	if bot.BaseURL == "" {
+		log.Print("BASE_URL is not set")
+		return false
+	}
+	if len(bot.URLSigningKey) == 0 {
+		log.Print("URL_SIGNING_KEY is not set")
+		return false
+	}
+	return true
+}
+
+// 宛先を保存してトークンを返す
+func (bot *Bot) issueLink(ctx context.Context, target *LinkTarget, ttl time.Duration) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	target.ExpiresAt = time.Now().Add(ttl)
+	if err := mystore.Save(ctx, bot.DatastoreClient, target, token, nil); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// トークンから宛先を読み込む．期限切れや用途が違うときはないものとして扱う
+func (bot *Bot) lookupLink(ctx context.Context, token string, purpose LinkPurpose) (*LinkTarget, error) {
+	if token == "" {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	target := LinkTarget{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &target, token, nil); err != nil {
+		return nil, err
+	}
+	if target.Purpose != purpose || target.Expired(time.Now()) {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return &target, nil
+}
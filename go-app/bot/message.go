@@ -3,21 +3,29 @@ package bot
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/export"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/nlquery"
 	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
-var (
-	radiusKey   = []string{"100m", "250m", "500m", "1km", "2km", "5km"}
-	radiusValue = []string{"100", "250", "500", "1000", "2000", "5000"}
-	radiusMap   = map[string]string{}
-)
+// 距離絞り込みの選択肢(m)
+var radiusOptions = []int{100, 250, 500, 1000, 2000, 5000, 10000, 20000, places.MaxRadius}
 
-func init() {
-	for i, val := range radiusKey {
-		radiusMap[radiusValue[i]] = val
+// 距離の表示．1km以上はkm単位にする
+func FormatRadius(radius string) string {
+	m, err := strconv.Atoi(radius)
+	if err != nil || m <= 0 {
+		return radius
+	}
+	if m < 1000 {
+		return fmt.Sprintf("%dm", m)
 	}
+	return strconv.FormatFloat(float64(m)/1000, 'f', -1, 64) + "km"
 }
 
 type PostbackAction string
@@ -25,10 +33,35 @@ type PostbackAction string
 const (
 	PostbackActionChangeRadius   PostbackAction = "changeRadius"
 	PostbackActionChangeKeyword  PostbackAction = "changeKeyword"
+	PostbackActionRemoveKeyword  PostbackAction = "removeKeyword"
 	PostbackActionUpdateRadius   PostbackAction = "updateRadius"
+	PostbackActionChangeTravel   PostbackAction = "changeTravel"
+	PostbackActionUpdateTravel   PostbackAction = "updateTravel"
 	PostbackActionNearbySearch   PostbackAction = "nearbySearch"
 	PostbackActionAddFavorite    PostbackAction = "addFavorite"
 	PostbackActionDeleteFavorite PostbackAction = "deleteFavorite"
+	// 登録地点
+	PostbackActionRegisterLocation    PostbackAction = "registerLocation"
+	PostbackActionUseSavedLocation    PostbackAction = "useSavedLocation"
+	PostbackActionDeleteSavedLocation PostbackAction = "deleteSavedLocation"
+	// 前回の条件
+	PostbackActionApplyLastSearch PostbackAction = "applyLastSearch"
+	// ルーレット
+	PostbackActionRoulette PostbackAction = "roulette"
+	// 投票
+	PostbackActionStartPoll PostbackAction = "startPoll"
+	PostbackActionVote      PostbackAction = "vote"
+	// お気に入りの表示と編集
+	PostbackActionShowFavorite         PostbackAction = "showFavorite"
+	PostbackActionEditFavorite         PostbackAction = "editFavorite"
+	PostbackActionToggleFavoriteTag    PostbackAction = "toggleFavoriteTag"
+	PostbackActionChooseFavoriteRating PostbackAction = "chooseFavoriteRating"
+	PostbackActionRateFavorite         PostbackAction = "rateFavorite"
+	// 訪問記録
+	PostbackActionVisit     PostbackAction = "visit"
+	PostbackActionRateVisit PostbackAction = "rateVisit"
+	// 検索履歴
+	PostbackActionReplaySearch PostbackAction = "replaySearch"
 )
 
 type PostbackData interface {
@@ -40,21 +73,44 @@ func (q *Query) PostbackData() {}
 type PlaceInfo struct {
 	PlaceID  string `json:"place_id"`
 	PhotoURI string `json:"photo_uri"`
+	// 共有リストを操作するときのリストID
+	ListID string `json:"list_id,omitempty"`
 }
 
 func (p *PlaceInfo) PostbackData() {}
 
+type LocationInfo struct {
+	Name string `json:"name"`
+}
+
+func (l *LocationInfo) PostbackData() {}
+
+// お気に入りのページ
+type FavoriteListInfo struct {
+	// 共有リストのときのリストID
+	ListID string `json:"list_id,omitempty"`
+	Page   int    `json:"page,omitempty"`
+	// タグで絞り込むとき
+	Tag string `json:"tag,omitempty"`
+}
+
+func (s *FavoriteListInfo) PostbackData() {}
+
 type Postback struct {
 	Action PostbackAction `json:"action"`
-	Data   PostbackData   `json:"data"`
+	Data   PostbackData   `json:"data,omitempty"`
+	// サーバに保存したデータのトークン
+	Token string `json:"token,omitempty"`
 }
 
+// ボタンのデータ．データは送信するときにサーバに保存し，トークンだけを埋め込む(prepareMessages)
 func PostbackJSON(action PostbackAction, pbData PostbackData) string {
-	b, _ := json.Marshal(&Postback{
+	data, _ := json.Marshal(pbData)
+	return postbackString(pendingPostback{
 		Action: action,
-		Data:   pbData,
+		Data:   data,
+		Token:  postbackToken(action, data),
 	})
-	return string(b)
 }
 
 func (pb *Postback) UnmarshalJSON(b []byte) error {
@@ -69,21 +125,15 @@ func (pb *Postback) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	switch a.Action {
-	case PostbackActionAddFavorite, PostbackActionDeleteFavorite:
-		p := new(PlaceInfo)
-		if err := json.Unmarshal(a.Data, p); err != nil {
-			return err
-		}
-		pb.Data = p
-	default:
-		q := new(Query)
-		if err := json.Unmarshal(a.Data, q); err != nil {
-			return err
-		}
-		pb.Data = q
+	// トークンだけのときはデータを後で読み込む
+	if len(a.Data) == 0 {
+		return nil
 	}
-
+	data, err := router.decodePostbackData(a.Action, a.Data)
+	if err != nil {
+		return err
+	}
+	pb.Data = data
 	return nil
 }
 
@@ -94,42 +144,167 @@ func LocationSendButton() *linebot.TemplateMessage {
 	return linebot.NewTemplateMessage("位置情報送信ボタン", button)
 }
 
+// 登録地点から選ぶクイックリプライ
+func SavedLocationsQuickReplyItems(locations []SavedLocation) *linebot.QuickReplyItems {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for i := range locations {
+		q := locations[i].Query()
+		label := truncate(locations[i].Name, 20)
+		postbackString := PostbackJSON(PostbackActionUseSavedLocation, &q)
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, postbackString, "", label))
+		buttons = append(buttons, b)
+	}
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
+// 登録地点を削除するクイックリプライボタン
+func DeleteSavedLocationQuickReply(locations []SavedLocation) linebot.SendingMessage {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for i := range locations {
+		info := LocationInfo{Name: locations[i].Name}
+		label := truncate(locations[i].Name, 20)
+		postbackString := PostbackJSON(PostbackActionDeleteSavedLocation, &info)
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, postbackString, "", ""))
+		buttons = append(buttons, b)
+	}
+	textMsg := linebot.NewTextMessage("削除する地点を選択してネ")
+	return textMsg.WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
+// 送信された位置で使うクイックリプライ
+func LocationQuickReplyItems(q *Query) *linebot.QuickReplyItems {
+	return linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("前回の条件を使う", PostbackJSON(PostbackActionApplyLastSearch, q), "", "")),
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("この場所を登録", PostbackJSON(PostbackActionRegisterLocation, q), "", "")),
+	)
+}
+
+// 前回の条件で検索するときのクイックリプライ
+func LastSearchQuickReplyItems() *linebot.QuickReplyItems {
+	return linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewLocationAction("現在地で検索")),
+	)
+}
+
+// 登録名の入力を促すメッセージ
+func LocationNameQuickReply() linebot.SendingMessage {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for _, name := range []string{"自宅", "会社", "学校"} {
+		text := LocationNamePrefix + name
+		b := linebot.NewQuickReplyButton("", linebot.NewMessageAction(name, text))
+		buttons = append(buttons, b)
+	}
+	textMsg := linebot.NewTextMessage("登録名を選ぶか，名前を入力してネ\n「" + CancelCommand + "」で登録をやめます")
+	return textMsg.WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
 // 検索確認ウィンドウ
 func SearchConfirmWindow(q *Query) *linebot.TemplateMessage {
 	label := map[string]string{}
 	if len(q.Keywords) == 0 {
 		label["changeKeyword"] = "キーワードで絞り込み"
 	} else {
-		label["changeKeyword"] = "キーワードを設定し直す"
+		label["changeKeyword"] = "キーワードを編集"
 	}
 	actions := []linebot.TemplateAction{
 		linebot.NewPostbackAction("距離で絞り込み", PostbackJSON(PostbackActionChangeRadius, q), "", ""),
 		linebot.NewPostbackAction(label["changeKeyword"], PostbackJSON(PostbackActionChangeKeyword, q), "", ""),
+		linebot.NewPostbackAction("移動時間を表示", PostbackJSON(PostbackActionChangeTravel, q), "", ""),
 		linebot.NewPostbackAction("検索する", PostbackJSON(PostbackActionNearbySearch, q), "", ""),
 	}
-	buttons := linebot.NewButtonsTemplate("", "絞り込みますか？", searchStatus(q), actions...)
+	// タイトルがあると本文は60文字まで
+	buttons := linebot.NewButtonsTemplate("", "絞り込みますか？", truncate(searchStatus(q), 60), actions...)
 	return linebot.NewTemplateMessage("確認ボタン", buttons)
 }
 
 func searchStatus(q *Query) string {
 	var str string
-	str += fmt.Sprintf("距離: %s\n", radiusMap[q.Radius])
+	if q.Address != "" {
+		// ボタンテンプレートの文字数制限(60文字)があるので短くする
+		str += fmt.Sprintf("場所: %s\n", truncate(q.Address, 20))
+	}
+	str += fmt.Sprintf("距離: %s\n", FormatRadius(q.Radius))
 	if len(q.Keywords) > 0 {
 		str += fmt.Sprintf("キーワード: %v\n", q.Keywords)
 	}
+	if c := conditionText(q); c != "" {
+		str += fmt.Sprintf("条件: %s\n", c)
+	}
+	if q.TravelMode != "" {
+		str += fmt.Sprintf("移動時間: %s", q.TravelMode.Label())
+		if q.SortByTravelTime {
+			str += "(近い順)"
+		}
+		str += "\n"
+	}
 	return str
 }
 
-// 距離絞り込み用のクイックリプライボタン
-func RadiusQuickReply(q *Query) linebot.SendingMessage {
+// 価格帯・営業中・種類の条件の表示
+func conditionText(q *Query) string {
+	conditions := []string{}
+	if q.Type != "" {
+		conditions = append(conditions, nlquery.TypeLabel(q.Type))
+	}
+	if q.MaxPrice > 0 {
+		conditions = append(conditions, strings.Repeat("¥", q.MaxPrice)+"まで")
+	}
+	if q.OpenNow {
+		conditions = append(conditions, "営業中")
+	}
+	return strings.Join(conditions, "・")
+}
+
+// 先頭からn文字に切り詰める
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// 距離絞り込み用のクイックリプライボタン．選択肢にない距離は入力してもらう
+func RadiusQuickReply(q *Query, group bool) linebot.SendingMessage {
 	buttons := make([]*linebot.QuickReplyButton, 0)
-	for i := range radiusKey {
-		q.Radius = radiusValue[i]
+	for _, m := range radiusOptions {
+		q.Radius = strconv.Itoa(m)
+		label := FormatRadius(q.Radius)
 		postbackString := PostbackJSON(PostbackActionUpdateRadius, q)
-		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(radiusKey[i], postbackString, "", radiusKey[i]))
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, postbackString, "", label))
+		buttons = append(buttons, b)
+	}
+	prefix := ""
+	if group {
+		prefix = GroupCommandPrefix
+	}
+	text := "検索範囲を選択するか，「" + prefix + "800m」「" + prefix + "1.5km」のように入力してネ(" + FormatRadius(strconv.Itoa(places.MaxRadius)) + "まで)"
+	textMsg := linebot.NewTextMessage(text)
+	return textMsg.WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
+// 移動時間の表示方法を選ぶクイックリプライボタン
+func TravelQuickReply(q *Query) linebot.SendingMessage {
+	options := []struct {
+		label string
+		mode  places.TravelMode
+		sort  bool
+	}{
+		{"表示しない", "", false},
+		{"徒歩", places.TravelModeWalking, false},
+		{"徒歩(近い順)", places.TravelModeWalking, true},
+		{"電車", places.TravelModeTransit, false},
+		{"電車(近い順)", places.TravelModeTransit, true},
+	}
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for _, opt := range options {
+		q.TravelMode = opt.mode
+		q.SortByTravelTime = opt.sort
+		postbackString := PostbackJSON(PostbackActionUpdateTravel, q)
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(opt.label, postbackString, "", opt.label))
 		buttons = append(buttons, b)
 	}
-	textMsg := linebot.NewTextMessage("検索範囲を選択してネ")
+	textMsg := linebot.NewTextMessage("移動時間の表示方法を選択してネ")
 	return textMsg.WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
 }
 
@@ -143,7 +318,7 @@ type PlaceBubble interface {
 
 type NearbyPlace places.Place
 
-type FavoritePlace places.Place
+type FavoritePlace FavoriteItem
 
 // メッセージバブルに変換
 func (p *NearbyPlace) MarshalBubble() *linebot.BubbleContainer {
@@ -151,6 +326,57 @@ func (p *NearbyPlace) MarshalBubble() *linebot.BubbleContainer {
 		PlaceID:  p.PlaceID,
 		PhotoURI: p.PhotoURI,
 	}
+	labels := []linebot.FlexComponent{}
+	notes := []linebot.FlexComponent{}
+	if p.TravelTime.Valid() {
+		notes = append(notes, TravelTimeText(p.TravelTime))
+	}
+	if !p.VisitedAt.IsZero() {
+		notes = append(notes, VisitedText(p.VisitedAt))
+	}
+	if p.Reason != "" {
+		labels = append(labels, RecommendLabel())
+		notes = append(notes, &linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   p.Reason,
+			Margin: linebot.FlexComponentMarginTypeMd,
+			Size:   linebot.FlexTextSizeTypeSm,
+			Color:  "#666666",
+			Wrap:   true,
+		})
+	}
+	return placeBubble((*places.Place)(p), labels, notes,
+		postbackButton("お気に入りに登録", PostbackActionAddFavorite, &info),
+		postbackButton("行った!", PostbackActionVisit, &info),
+	)
+}
+
+// お店のメッセージバブル．
+// 写真，店名，評価の並びと「マップで見る」は共通にして，店名の上のラベル，評価の下の行，ボタンを使う場所ごとに変える
+func placeBubble(p *places.Place, labels, notes []linebot.FlexComponent, buttons ...linebot.FlexComponent) *linebot.BubbleContainer {
+	bodyContents := append([]linebot.FlexComponent{}, labels...)
+	bodyContents = append(bodyContents,
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   p.Name,
+			Size:   linebot.FlexTextSizeTypeLg,
+			Weight: linebot.FlexTextWeightTypeBold,
+			Wrap:   true,
+		},
+		&linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeBaseline,
+			Contents: RatingStars(p.Rating),
+			Margin:   linebot.FlexComponentMarginTypeMd,
+		},
+	)
+	bodyContents = append(bodyContents, notes...)
+	footerContents := append([]linebot.FlexComponent{}, buttons...)
+	footerContents = append(footerContents, &linebot.ButtonComponent{
+		Type:   linebot.FlexComponentTypeButton,
+		Action: linebot.NewURIAction("マップで見る", p.GooglemapURI),
+		Height: linebot.FlexButtonHeightTypeSm,
+	})
 	bubble := linebot.BubbleContainer{
 		Type: linebot.FlexContainerTypeBubble,
 		Size: linebot.FlexBubbleSizeTypeKilo,
@@ -161,76 +387,133 @@ func (p *NearbyPlace) MarshalBubble() *linebot.BubbleContainer {
 			AspectMode: linebot.FlexImageAspectModeTypeCover,
 		},
 		Body: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   p.Name,
-					Size:   linebot.FlexTextSizeTypeLg,
-					Weight: linebot.FlexTextWeightTypeBold,
-					Wrap:   true,
-				},
-				&linebot.BoxComponent{
-					Type:     linebot.FlexComponentTypeBox,
-					Layout:   linebot.FlexBoxLayoutTypeBaseline,
-					Contents: RatingStars(p.Rating),
-					Margin:   linebot.FlexComponentMarginTypeMd,
-				},
-			},
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: bodyContents,
 		},
 		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.ButtonComponent{
-					Type:   linebot.FlexComponentTypeButton,
-					Action: linebot.NewPostbackAction("お気に入りに登録", PostbackJSON(PostbackActionAddFavorite, &info), "", ""),
-					Height: linebot.FlexButtonHeightTypeSm,
-				},
-				&linebot.ButtonComponent{
-					Type:   linebot.FlexComponentTypeButton,
-					Action: linebot.NewURIAction("マップで見る", p.GooglemapURI),
-					Height: linebot.FlexButtonHeightTypeSm,
-				},
-			},
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: footerContents,
 		},
 	}
 	return &bubble
 }
 
+// バブルの下のボタン
+func postbackButton(label string, action PostbackAction, data PostbackData) *linebot.ButtonComponent {
+	return &linebot.ButtonComponent{
+		Type:   linebot.FlexComponentTypeButton,
+		Action: linebot.NewPostbackAction(label, PostbackJSON(action, data), "", ""),
+		Height: linebot.FlexButtonHeightTypeSm,
+	}
+}
+
 // メッセージバブルに変換
 func (p *FavoritePlace) MarshalBubble() *linebot.BubbleContainer {
-	info := PlaceInfo{
-		PlaceID: p.PlaceID,
+	return favoriteBubble((*FavoriteItem)(p), "", false)
+}
+
+// 共有リストのお店
+type SharedFavoritePlace struct {
+	ListID string
+	Item   *FavoriteItem
+}
+
+// メッセージバブルに変換
+func (p *SharedFavoritePlace) MarshalBubble() *linebot.BubbleContainer {
+	return favoriteBubble(p.Item, p.ListID, true)
+}
+
+// 近くのお気に入りのお店
+type NearFavoritePlace NearFavorite
+
+// メッセージバブルに変換
+func (p *NearFavoritePlace) MarshalBubble() *linebot.BubbleContainer {
+	item := &p.Item
+	label := "近くのお気に入り"
+	if !p.Favorite {
+		label = "近くの行ったお店"
+	}
+	labels := []linebot.FlexComponent{
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   label + " " + distanceText(p.Distance),
+			Size:   linebot.FlexTextSizeTypeXs,
+			Color:  "#1db446",
+			Weight: linebot.FlexTextWeightTypeBold,
+		},
+	}
+	notes := []linebot.FlexComponent{}
+	if !p.VisitedAt.IsZero() {
+		notes = append(notes, VisitedText(p.VisitedAt))
+	}
+	notes = append(notes, favoriteNoteTexts(item)...)
+	buttons := []linebot.FlexComponent{}
+	if !p.Favorite {
+		info := PlaceInfo{
+			PlaceID:  item.PlaceID,
+			PhotoURI: item.PhotoURI,
+		}
+		buttons = append(buttons, postbackButton("お気に入りに登録", PostbackActionAddFavorite, &info))
+	}
+	buttons = append(buttons, postbackButton("行った!", PostbackActionVisit, &PlaceInfo{PlaceID: item.PlaceID}))
+	return placeBubble(&item.Place, labels, notes, buttons...)
+}
+
+// 検索履歴のバブル
+type SearchRecordBubble SearchRecord
+
+// メッセージバブルに変換
+func (r *SearchRecordBubble) MarshalBubble() *linebot.BubbleContainer {
+	q := &r.Query
+	line := func(text string) *linebot.TextComponent {
+		return &linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   text,
+			Margin: linebot.FlexComponentMarginTypeMd,
+			Size:   linebot.FlexTextSizeTypeSm,
+			Wrap:   true,
+		}
+	}
+	place := q.Address
+	if place == "" {
+		place = "送信した位置情報"
+	}
+	bodyContents := []linebot.FlexComponent{
+		&linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   r.SearchedAt.In(jst).Format("1/2 15:04"),
+			Size:   linebot.FlexTextSizeTypeLg,
+			Weight: linebot.FlexTextWeightTypeBold,
+		},
+		line("場所: " + truncate(place, 20)),
+		line("距離: " + FormatRadius(q.Radius)),
+	}
+	if len(q.Keywords) > 0 {
+		bodyContents = append(bodyContents, line("キーワード: "+truncate(strings.Join(q.Keywords, " "), 30)))
 	}
+	if c := conditionText(q); c != "" {
+		bodyContents = append(bodyContents, line("条件: "+c))
+	}
+	if q.TravelMode != "" {
+		bodyContents = append(bodyContents, line("移動時間: "+q.TravelMode.Label()))
+	}
+	bodyContents = append(bodyContents, &linebot.TextComponent{
+		Type:   linebot.FlexComponentTypeText,
+		Text:   fmt.Sprintf("%d件見つかりました", r.ResultCount),
+		Margin: linebot.FlexComponentMarginTypeMd,
+		Size:   linebot.FlexTextSizeTypeXs,
+		Color:  "#666666",
+	})
+	mapURI := "https://www.google.com/maps/search/?api=1&query=" + q.Lat + "," + q.Lng
 	bubble := linebot.BubbleContainer{
 		Type: linebot.FlexContainerTypeBubble,
 		Size: linebot.FlexBubbleSizeTypeKilo,
-		Hero: &linebot.ImageComponent{
-			Type:       linebot.FlexComponentTypeImage,
-			URL:        p.PhotoURI,
-			Size:       linebot.FlexImageSizeTypeFull,
-			AspectMode: linebot.FlexImageAspectModeTypeCover,
-		},
 		Body: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   p.Name,
-					Size:   linebot.FlexTextSizeTypeLg,
-					Weight: linebot.FlexTextWeightTypeBold,
-					Wrap:   true,
-				},
-				&linebot.BoxComponent{
-					Type:     linebot.FlexComponentTypeBox,
-					Layout:   linebot.FlexBoxLayoutTypeBaseline,
-					Contents: RatingStars(p.Rating),
-					Margin:   linebot.FlexComponentMarginTypeMd,
-				},
-			},
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: bodyContents,
 		},
 		Footer: &linebot.BoxComponent{
 			Type:   linebot.FlexComponentTypeBox,
@@ -238,12 +521,12 @@ func (p *FavoritePlace) MarshalBubble() *linebot.BubbleContainer {
 			Contents: []linebot.FlexComponent{
 				&linebot.ButtonComponent{
 					Type:   linebot.FlexComponentTypeButton,
-					Action: linebot.NewPostbackAction("お気に入りから削除", PostbackJSON(PostbackActionDeleteFavorite, &info), "", ""),
+					Action: linebot.NewPostbackAction("この条件で検索", PostbackJSON(PostbackActionReplaySearch, &SearchHistoryInfo{ID: (*SearchRecord)(r).ID()}), "", ""),
 					Height: linebot.FlexButtonHeightTypeSm,
 				},
 				&linebot.ButtonComponent{
 					Type:   linebot.FlexComponentTypeButton,
-					Action: linebot.NewURIAction("マップで見る", p.GooglemapURI),
+					Action: linebot.NewURIAction("場所を地図で見る", mapURI),
 					Height: linebot.FlexButtonHeightTypeSm,
 				},
 			},
@@ -252,6 +535,252 @@ func (p *FavoritePlace) MarshalBubble() *linebot.BubbleContainer {
 	return &bubble
 }
 
+// お気に入りのメッセージバブル
+func favoriteBubble(item *FavoriteItem, listID string, shared bool) *linebot.BubbleContainer {
+	info := PlaceInfo{
+		PlaceID: item.PlaceID,
+		ListID:  listID,
+	}
+	deleteLabel := "お気に入りから削除"
+	if shared {
+		deleteLabel = "共有リストから削除"
+	}
+	return placeBubble(&item.Place, nil, favoriteNoteTexts(item),
+		postbackButton("タグ・メモ・評価", PostbackActionEditFavorite, &info),
+		postbackButton("行った!", PostbackActionVisit, &PlaceInfo{PlaceID: item.PlaceID}),
+		postbackButton(deleteLabel, PostbackActionDeleteFavorite, &info),
+	)
+}
+
+// お気に入りにつけたタグ，評価，メモ
+func favoriteNoteTexts(item *FavoriteItem) []linebot.FlexComponent {
+	texts := make([]linebot.FlexComponent, 0)
+	note := func(text string) *linebot.TextComponent {
+		return &linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   text,
+			Margin: linebot.FlexComponentMarginTypeMd,
+			Size:   linebot.FlexTextSizeTypeSm,
+			Color:  "#666666",
+			Wrap:   true,
+		}
+	}
+	if len(item.Tags) > 0 {
+		texts = append(texts, note("#"+strings.Join(item.Tags, " #")))
+	}
+	if item.MyRating > 0 {
+		texts = append(texts, note("じぶん評価: "+strings.Repeat("★", item.MyRating)+strings.Repeat("☆", MaxMyRating-item.MyRating)))
+	}
+	if item.Memo != "" {
+		texts = append(texts, note("メモ: "+truncate(item.Memo, 60)))
+	}
+	if item.AddedBy != "" {
+		texts = append(texts, note(item.AddedBy+"さんが追加"))
+	}
+	return texts
+}
+
+// お気に入りの編集メニュー
+func FavoriteEditQuickReply(item *FavoriteItem, listID string) linebot.SendingMessage {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	tags := append([]string{}, presetTags...)
+	for _, t := range item.Tags {
+		if !containsString(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	// クイックリプライは13個まで
+	if len(tags) > 10 {
+		tags = tags[:10]
+	}
+	for _, tag := range tags {
+		info := FavoriteEditInfo{PlaceID: item.PlaceID, ListID: listID, Tag: tag}
+		label := "#" + tag
+		if item.HasTag(tag) {
+			label = "✓" + tag
+		}
+		label = truncate(label, 20)
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, PostbackJSON(PostbackActionToggleFavoriteTag, &info), "", ""))
+		buttons = append(buttons, b)
+	}
+	rateInfo := FavoriteEditInfo{PlaceID: item.PlaceID, ListID: listID}
+	buttons = append(buttons,
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("評価する", PostbackJSON(PostbackActionChooseFavoriteRating, &rateInfo), "", "")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction("メモを書く", MemoPrefix)),
+	)
+	text := favoriteSummary(item) + "\n\nタグを選ぶか「" + MemoPrefix + "内容」「" + TagPrefix + "名前」の形式で送信してネ"
+	return linebot.NewTextMessage(text).WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
+// じぶん評価の選択肢
+func FavoriteRatingQuickReply(info *FavoriteEditInfo) linebot.SendingMessage {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for rating := MaxMyRating; rating >= 0; rating-- {
+		rateInfo := *info
+		rateInfo.Rating = rating
+		label := strings.Repeat("★", rating)
+		if rating == 0 {
+			label = "評価を消す"
+		}
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, PostbackJSON(PostbackActionRateFavorite, &rateInfo), "", ""))
+		buttons = append(buttons, b)
+	}
+	return linebot.NewTextMessage("評価を選択してネ").WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
+// お気に入りの表示につけるクイックリプライ
+func FavoriteListQuickReplyItems(list []FavoriteItem, info *FavoriteListInfo) *linebot.QuickReplyItems {
+	buttons := []*linebot.QuickReplyButton{
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("ルーレット", PostbackJSON(PostbackActionRoulette, &RouletteInfo{}), "", "ルーレット")),
+	}
+	if info.Tag != "" {
+		all := FavoriteListInfo{ListID: info.ListID}
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction("すべて表示", PostbackJSON(PostbackActionShowFavorite, &all), "", "")))
+	}
+	for _, tag := range FavoriteTags(list) {
+		if len(buttons) == 13 {
+			break
+		}
+		if tag == info.Tag {
+			continue
+		}
+		tagInfo := FavoriteListInfo{ListID: info.ListID, Tag: tag}
+		label := truncate("#"+tag, 20)
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, PostbackJSON(PostbackActionShowFavorite, &tagInfo), "", label)))
+	}
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 共有リストから選ぶクイックリプライボタン
+func SharedListsQuickReply(lists []sharedList) linebot.SendingMessage {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for _, list := range lists {
+		info := FavoriteListInfo{ListID: list.ID}
+		label := truncate(list.Name, 20)
+		postbackString := PostbackJSON(PostbackActionShowFavorite, &info)
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, postbackString, "", ""))
+		buttons = append(buttons, b)
+	}
+	textMsg := linebot.NewTextMessage("共有リストを選択してネ")
+	return textMsg.WithQuickReplies(linebot.NewQuickReplyItems(buttons...))
+}
+
+// ページ送りのボタン
+type PageNavigation struct {
+	Text string
+	Prev *FavoriteListInfo
+	Next *FavoriteListInfo
+}
+
+// メッセージバブルに変換
+func (nav *PageNavigation) MarshalBubble() *linebot.BubbleContainer {
+	buttons := make([]linebot.FlexComponent, 0)
+	if nav.Prev != nil {
+		buttons = append(buttons, &linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Action: linebot.NewPostbackAction("前へ", PostbackJSON(PostbackActionShowFavorite, nav.Prev), "", ""),
+			Height: linebot.FlexButtonHeightTypeSm,
+		})
+	}
+	if nav.Next != nil {
+		buttons = append(buttons, &linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Action: linebot.NewPostbackAction("次へ", PostbackJSON(PostbackActionShowFavorite, nav.Next), "", ""),
+			Height: linebot.FlexButtonHeightTypeSm,
+			Style:  linebot.FlexButtonStyleTypePrimary,
+		})
+	}
+	bubble := linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Size: linebot.FlexBubbleSizeTypeKilo,
+		Body: &linebot.BoxComponent{
+			Type:    linebot.FlexComponentTypeBox,
+			Layout:  linebot.FlexBoxLayoutTypeVertical,
+			Spacing: linebot.FlexComponentSpacingTypeMd,
+			Contents: append([]linebot.FlexComponent{
+				&linebot.TextComponent{
+					Type:  linebot.FlexComponentTypeText,
+					Text:  nav.Text,
+					Align: linebot.FlexComponentAlignTypeCenter,
+					Color: "#999999",
+					Wrap:  true,
+				},
+			}, buttons...),
+		},
+	}
+	return &bubble
+}
+
+// ページ分けしたカルーセル
+type PagedCarousel struct {
+	Items PlacesCarousel
+	Nav   *PageNavigation
+}
+
+// ページの最後にページ送りのバブルを加える
+func (p *PagedCarousel) PlaceBubbles(maxBubble int) []PlaceBubble {
+	if p.Nav == nil {
+		return p.Items.PlaceBubbles(maxBubble)
+	}
+	return append(p.Items.PlaceBubbles(maxBubble-1), p.Nav)
+}
+
+// 代替テキスト
+func (p *PagedCarousel) AltText() string {
+	return p.Items.AltText()
+}
+
+func (p *PagedCarousel) Len() int {
+	if p.Nav == nil {
+		return p.Items.Len()
+	}
+	return p.Items.Len() + 1
+}
+
+// ページの範囲とページ送りを求める
+// 1ページにはページ送りの分を除いてsize件表示する
+func Paginate(total, size int, info *FavoriteListInfo) (start, end int, nav *PageNavigation) {
+	pages := (total + size - 1) / size
+	page := info.Page
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	start = page * size
+	end = start + size
+	if end > total {
+		end = total
+	}
+	if pages <= 1 {
+		return start, end, nil
+	}
+	nav = &PageNavigation{
+		Text: fmt.Sprintf("%d〜%d件目 / 全%d件", start+1, end, total),
+	}
+	if page > 0 {
+		prev := *info
+		prev.Page = page - 1
+		nav.Prev = &prev
+	}
+	if page < pages-1 {
+		next := *info
+		next.Page = page + 1
+		nav.Next = &next
+	}
+	return start, end, nav
+}
+
 type PlacesCarousel interface {
 	PlaceBubbles(maxBubble int) []PlaceBubble
 	AltText() string
@@ -265,6 +794,97 @@ func CarouselMessage(p PlacesCarousel, maxBubble int) *linebot.FlexMessage {
 	return linebot.NewFlexMessage(altText, carousel)
 }
 
+// キーワードを1つずつ削除するクイックリプライ
+func KeywordQuickReplyItems(q *Query) *linebot.QuickReplyItems {
+	if len(q.Keywords) == 0 {
+		return nil
+	}
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for _, k := range q.Keywords {
+		// クイックリプライは13個まで
+		if len(buttons) >= 12 {
+			break
+		}
+		label := "✕ " + truncate(k, 16)
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, PostbackJSON(PostbackActionRemoveKeyword, &KeywordInfo{Keyword: k}), "", "")))
+	}
+	if len(q.Keywords) > 1 {
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction("すべて削除", PostbackJSON(PostbackActionRemoveKeyword, &KeywordInfo{All: true}), "", "")))
+	}
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
+// 検索結果につけるクイックリプライ
+func SearchResultQuickReplyItems(q *Query, p places.Places, group bool) *linebot.QuickReplyItems {
+	buttons := []*linebot.QuickReplyButton{
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("ルーレット", PostbackJSON(PostbackActionRoulette, &RouletteInfo{Candidates: p}), "", "ルーレット")),
+	}
+	if group {
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction("投票を始める", PostbackJSON(PostbackActionStartPoll, q), "", "投票を始める")))
+	}
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
+// エクスポート用リンクのボタン
+func ExportLinksMessage(n int, urls map[export.Format]string) *linebot.TemplateMessage {
+	labels := map[export.Format]string{
+		export.FormatCSV:     "CSV(表計算)",
+		export.FormatGeoJSON: "GeoJSON",
+		export.FormatKML:     "KML(マイマップ)",
+	}
+	actions := []linebot.TemplateAction{}
+	for _, f := range export.Formats {
+		actions = append(actions, linebot.NewURIAction(labels[f], urls[f]))
+	}
+	text := fmt.Sprintf("%d件のお店を書き出します\nリンクは%d分間有効です", n, int(ExportLinkTTL.Minutes()))
+	buttons := linebot.NewButtonsTemplate("", "エクスポート", text, actions...)
+	return linebot.NewTemplateMessage("エクスポート", buttons)
+}
+
+// インポート画面へのリンクのボタン
+func ImportLinkMessage(uri string) *linebot.TemplateMessage {
+	text := fmt.Sprintf("Googleマップの保存済みの場所やCSVをお気に入りに取り込みます\nリンクは%d分間有効です", int(ImportLinkTTL.Minutes()))
+	buttons := linebot.NewButtonsTemplate("", "", text, linebot.NewURIAction("ファイルを選ぶ", uri))
+	return linebot.NewTemplateMessage("インポート", buttons)
+}
+
+// ルーレットで選ばれたお店のメッセージ
+func RouletteMessage(p *places.Place, favorite bool, next *RouletteInfo) linebot.SendingMessage {
+	var bubble *linebot.BubbleContainer
+	if favorite {
+		bubble = (*FavoritePlace)(&FavoriteItem{Place: *p}).MarshalBubble()
+	} else {
+		bubble = (*NearbyPlace)(p).MarshalBubble()
+	}
+	bubble.Header = &linebot.BoxComponent{
+		Type:   linebot.FlexComponentTypeBox,
+		Layout: linebot.FlexBoxLayoutTypeVertical,
+		Contents: []linebot.FlexComponent{
+			&linebot.TextComponent{
+				Type:   linebot.FlexComponentTypeText,
+				Text:   "今日はここ!",
+				Size:   linebot.FlexTextSizeTypeXl,
+				Weight: linebot.FlexTextWeightTypeBold,
+				Color:  "#ff5551",
+				Align:  linebot.FlexComponentAlignTypeCenter,
+			},
+		},
+	}
+	postbackString := PostbackJSON(PostbackActionRoulette, next)
+	// 最近行ったお店を候補に含めるかを切り替えて回す
+	toggled := *next
+	toggled.IncludeVisited = !next.IncludeVisited
+	toggleLabel := "行ったお店も含める"
+	if next.IncludeVisited {
+		toggleLabel = "行ったお店を除く"
+	}
+	items := linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("もう一回", postbackString, "", "もう一回")),
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction(toggleLabel, PostbackJSON(PostbackActionRoulette, &toggled), "", toggleLabel)),
+	)
+	return linebot.NewFlexMessage("ルーレットの結果", bubble).WithQuickReplies(items)
+}
+
 // カルーセルに変換
 func MarshalCarousel(p PlacesCarousel, maxBubble int) *linebot.CarouselContainer {
 	placeBubbles := p.PlaceBubbles(maxBubble)
@@ -282,7 +902,40 @@ func MarshalCarousel(p PlacesCarousel, maxBubble int) *linebot.CarouselContainer
 
 type NearbyPlaces places.Places
 
-type FavoritePlaces places.Places
+// 投票の候補
+type PollPlaces struct {
+	Poll *Poll
+}
+
+// 得票数つきの投票候補
+type PollPlace struct {
+	Place *places.Place
+	Votes int
+}
+
+// メッセージバブルに変換
+func (p *PollPlace) MarshalBubble() *linebot.BubbleContainer {
+	bubble := (*NearbyPlace)(p.Place).MarshalBubble()
+	info := PlaceInfo{
+		PlaceID: p.Place.PlaceID,
+	}
+	bubble.Footer.Contents = []linebot.FlexComponent{
+		&linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Action: linebot.NewPostbackAction(fmt.Sprintf("投票する (%d票)", p.Votes), PostbackJSON(PostbackActionVote, &info), "", ""),
+			Height: linebot.FlexButtonHeightTypeSm,
+			Style:  linebot.FlexButtonStyleTypePrimary,
+		},
+		&linebot.ButtonComponent{
+			Type:   linebot.FlexComponentTypeButton,
+			Action: linebot.NewURIAction("マップで見る", p.Place.GooglemapURI),
+			Height: linebot.FlexButtonHeightTypeSm,
+		},
+	}
+	return bubble
+}
+
+type FavoritePlaces []FavoriteItem
 
 // 複数のメッセージバブルに変換
 func (p *NearbyPlaces) PlaceBubbles(maxBubble int) []PlaceBubble {
@@ -304,6 +957,92 @@ func (p *FavoritePlaces) PlaceBubbles(maxBubble int) []PlaceBubble {
 	return bubbles
 }
 
+// 複数のメッセージバブルに変換
+func (p *PollPlaces) PlaceBubbles(maxBubble int) []PlaceBubble {
+	counts := p.Poll.Counts()
+	bubbles := make([]PlaceBubble, 0)
+	for i := 0; i < p.Len() && i < maxBubble; i++ {
+		placePtr := &p.Poll.Candidates[i]
+		bubbles = append(bubbles, &PollPlace{Place: placePtr, Votes: counts[placePtr.PlaceID]})
+	}
+	return bubbles
+}
+
+// 共有リスト
+type SharedFavoritePlaces struct {
+	ListID string
+	List   []FavoriteItem
+}
+
+// 複数のメッセージバブルに変換
+func (p *SharedFavoritePlaces) PlaceBubbles(maxBubble int) []PlaceBubble {
+	bubbles := make([]PlaceBubble, 0)
+	for i := 0; i < p.Len() && i < maxBubble; i++ {
+		bubbles = append(bubbles, &SharedFavoritePlace{ListID: p.ListID, Item: &p.List[i]})
+	}
+	return bubbles
+}
+
+// 代替テキスト
+func (p *SharedFavoritePlaces) AltText() string {
+	return "共有リスト"
+}
+
+func (p *SharedFavoritePlaces) Len() int {
+	return len(p.List)
+}
+
+// 近くのお気に入り
+type NearFavoritePlaces []NearFavorite
+
+// 複数のメッセージバブルに変換
+func (p *NearFavoritePlaces) PlaceBubbles(maxBubble int) []PlaceBubble {
+	bubbles := make([]PlaceBubble, 0)
+	for i := 0; i < p.Len() && i < maxBubble; i++ {
+		bubbles = append(bubbles, (*NearFavoritePlace)(&(*p)[i]))
+	}
+	return bubbles
+}
+
+// 代替テキスト
+func (p *NearFavoritePlaces) AltText() string {
+	return "近くのお気に入り"
+}
+
+func (p *NearFavoritePlaces) Len() int {
+	return len(*p)
+}
+
+// 検索履歴
+type SearchRecords []SearchRecord
+
+// 複数のメッセージバブルに変換
+func (p *SearchRecords) PlaceBubbles(maxBubble int) []PlaceBubble {
+	bubbles := make([]PlaceBubble, 0)
+	for i := 0; i < p.Len() && i < maxBubble; i++ {
+		bubbles = append(bubbles, (*SearchRecordBubble)(&(*p)[i]))
+	}
+	return bubbles
+}
+
+// 代替テキスト
+func (p *SearchRecords) AltText() string {
+	return "検索履歴"
+}
+
+func (p *SearchRecords) Len() int {
+	return len(*p)
+}
+
+// 代替テキスト
+func (p *PollPlaces) AltText() string {
+	return "投票"
+}
+
+func (p *PollPlaces) Len() int {
+	return len(p.Poll.Candidates)
+}
+
 // 代替テキスト
 func (p *NearbyPlaces) AltText() string {
 	return "検索結果"
@@ -345,6 +1084,52 @@ func RatingStars(rating float64) []linebot.FlexComponent {
 	return stars
 }
 
+// 移動時間のテキスト
+func TravelTimeText(t places.TravelTime) *linebot.TextComponent {
+	return &linebot.TextComponent{
+		Type:   linebot.FlexComponentTypeText,
+		Text:   fmt.Sprintf("%s %s", t.Mode.Label(), t.Text),
+		Margin: linebot.FlexComponentMarginTypeMd,
+		Size:   linebot.FlexTextSizeTypeSm,
+		Color:  "#666666",
+	}
+}
+
+// 行ったことがあるお店の印
+func VisitedText(visitedAt time.Time) *linebot.TextComponent {
+	return &linebot.TextComponent{
+		Type:   linebot.FlexComponentTypeText,
+		Text:   fmt.Sprintf("✔ 行ったことあり (%s)", visitedAt.In(jst).Format("1/2")),
+		Margin: linebot.FlexComponentMarginTypeMd,
+		Size:   linebot.FlexTextSizeTypeSm,
+		Color:  "#1db446",
+	}
+}
+
+// おすすめのお店につけるラベル
+func RecommendLabel() *linebot.TextComponent {
+	return &linebot.TextComponent{
+		Type:   linebot.FlexComponentTypeText,
+		Text:   "あなたへのおすすめ",
+		Size:   linebot.FlexTextSizeTypeXs,
+		Color:  "#ff8c00",
+		Weight: linebot.FlexTextWeightTypeBold,
+	}
+}
+
+// 訪問記録の評価を選ぶクイックリプライ
+func VisitRatingQuickReplyItems(id int64) *linebot.QuickReplyItems {
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for rating := MaxVisitRating; rating >= 1; rating-- {
+		info := VisitInfo{ID: id, Rating: rating}
+		label := strings.Repeat("★", rating)
+		b := linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, PostbackJSON(PostbackActionRateVisit, &info), "", ""))
+		buttons = append(buttons, b)
+	}
+	buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewMessageAction("感想を書く", CommentPrefix)))
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
 // 星アイコンのURI
 func StarIconURI(gold bool) string {
 	base := "https://scdn.line-apps.com/n/channel_devcenter/img/fx/"
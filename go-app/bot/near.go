@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/richmenu"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 近くのお気に入りとして表示する最大件数
+const MaxNearFavorites int = 5
+
+// 送られた位置の近くにあるお気に入りや行ったお店
+type NearFavorite struct {
+	Item      FavoriteItem
+	Distance  float64
+	Favorite  bool
+	VisitedAt time.Time
+}
+
+// 近くのお気に入りを距離の近い順に探す．
+// グループではグループの共有リスト，1対1ではお気に入りと訪問記録から探す
+func (bot *Bot) NearFavorites(ctx context.Context, scope Scope, q *Query) []NearFavorite {
+	radius, err := strconv.ParseFloat(q.Radius, 64)
+	if err != nil {
+		return nil
+	}
+	origin := places.LatLng{Lat: q.Lat, Lng: q.Lng}
+
+	var list []FavoriteItem
+	var visits []Visit
+	if scope.IsGroup() {
+		shared := SharedFavorite{}
+		err := mystore.Get(ctx, bot.DatastoreClient, &shared, scope.Key(), nil)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			log.Print(err)
+		}
+		list = shared.List
+	} else {
+		f, err := bot.getFavorite(ctx, scope.UserID)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			log.Print(err)
+		}
+		list = f.List
+		if l, err := bot.getVisitLog(ctx, scope.UserID); err == nil {
+			visits = l.Visits
+		}
+	}
+
+	near := map[string]*NearFavorite{}
+	for _, item := range list {
+		d, err := places.Distance(origin, item.LatLng())
+		if err != nil || d > radius {
+			continue
+		}
+		near[item.PlaceID] = &NearFavorite{Item: item, Distance: d, Favorite: true}
+	}
+	// 訪問記録は新しい順なので最初に見つかったものが最後に行った日
+	for _, v := range visits {
+		if nf, ok := near[v.PlaceID]; ok {
+			if nf.VisitedAt.IsZero() {
+				nf.VisitedAt = v.VisitedAt
+			}
+			continue
+		}
+		loc := places.LatLng{Lat: v.Lat, Lng: v.Lng}
+		d, err := places.Distance(origin, loc)
+		if err != nil || d > radius {
+			continue
+		}
+		item := FavoriteItem{Place: places.Place{
+			PlaceID:      v.PlaceID,
+			Name:         v.Name,
+			PhotoURI:     places.AlternativePhotoURI(),
+			GooglemapURI: places.GooglemapSearchURI(loc, v.PlaceID),
+			Lat:          v.Lat,
+			Lng:          v.Lng,
+		}}
+		near[v.PlaceID] = &NearFavorite{Item: item, Distance: d, VisitedAt: v.VisitedAt}
+	}
+
+	result := make([]NearFavorite, 0, len(near))
+	for _, nf := range near {
+		result = append(result, *nf)
+	}
+	// お気に入りを優先して近い順
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Favorite != result[j].Favorite {
+			return result[i].Favorite
+		}
+		return result[i].Distance < result[j].Distance
+	})
+	if len(result) > MaxNearFavorites {
+		result = result[:MaxNearFavorites]
+	}
+	return result
+}
+
+// 検索確認ウィンドウの前に近くのお気に入りを添えて返信する
+func (bot *Bot) replyWithNearFavorites(ctx context.Context, event *linebot.Event, q *Query, messages ...linebot.SendingMessage) {
+	near := NearFavoritePlaces(bot.NearFavorites(ctx, NewScope(event.Source), q))
+	if near.Len() > 0 {
+		messages = append([]linebot.SendingMessage{CarouselMessage(&near, MaxNearFavorites)}, messages...)
+	}
+	bot.SwitchRichMenu(ctx, event, richmenu.Searching)
+	bot.ReplyMessage(ctx, event, messages...)
+}
+
+// 距離の表示
+func distanceText(meters float64) string {
+	if meters < 1000 {
+		// 10m単位に丸める
+		return fmt.Sprintf("約%dm", int(meters/10+0.5)*10)
+	}
+	return fmt.Sprintf("約%.1fkm", meters/1000)
+}
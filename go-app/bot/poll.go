@@ -0,0 +1,366 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 投票の受付時間
+const PollDuration = 30 * time.Minute
+
+// グループでの投票
+type Poll struct {
+	Candidates []places.Place `datastore:"candidates,noindex"`
+	Votes      []Vote         `datastore:"votes,noindex"`
+	Deadline   time.Time      `datastore:"deadline,noindex"`
+	Closed     bool           `datastore:"closed,noindex"`
+	// 受付中の投票の締切．定期実行で締切を過ぎた投票を探すのに使い，締め切ったら空にする
+	OpenUntil time.Time `datastore:"open_until,omitempty"`
+	// 結果を知らせるグループやトークルームのID
+	To string `datastore:"to,noindex"`
+	mystore.Timestamp
+}
+
+// 1人1票
+type Vote struct {
+	UserID  string `datastore:"user_id,noindex"`
+	PlaceID string `datastore:"place_id,noindex"`
+}
+
+func (poll *Poll) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("Poll", name, parent)
+}
+
+func NewPoll(candidates places.Places, to string, now time.Time) Poll {
+	deadline := now.Add(PollDuration)
+	return Poll{
+		Candidates: candidates,
+		Votes:      []Vote{},
+		Deadline:   deadline,
+		OpenUntil:  deadline,
+		To:         to,
+	}
+}
+
+// 締切を過ぎたか
+func (poll *Poll) Expired(now time.Time) bool {
+	return !now.Before(poll.Deadline)
+}
+
+// 投票を受け付けているか
+func (poll *Poll) Open(now time.Time) bool {
+	return !poll.Closed && !poll.Expired(now)
+}
+
+// 候補に含まれるか
+func (poll *Poll) HasCandidate(placeID string) bool {
+	for _, c := range poll.Candidates {
+		if c.PlaceID == placeID {
+			return true
+		}
+	}
+	return false
+}
+
+// 投票する．投票済みなら投票先を変更する
+func (poll *Poll) Vote(userID, placeID string) {
+	for i := range poll.Votes {
+		if poll.Votes[i].UserID == userID {
+			poll.Votes[i].PlaceID = placeID
+			return
+		}
+	}
+	poll.Votes = append(poll.Votes, Vote{UserID: userID, PlaceID: placeID})
+}
+
+// 候補ごとの得票数
+func (poll *Poll) Counts() map[string]int {
+	counts := map[string]int{}
+	for _, v := range poll.Votes {
+		counts[v.PlaceID]++
+	}
+	return counts
+}
+
+// 最多得票の候補(同数なら全て)
+func (poll *Poll) Winners() places.Places {
+	counts := poll.Counts()
+	max := 0
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
+	}
+	winners := places.Places{}
+	if max == 0 {
+		return winners
+	}
+	for _, c := range poll.Candidates {
+		if counts[c.PlaceID] == max {
+			winners = append(winners, c)
+		}
+	}
+	return winners
+}
+
+// 得票数の多い順に並べた途中経過
+func (poll *Poll) Standings() string {
+	counts := poll.Counts()
+	candidates := make(places.Places, len(poll.Candidates))
+	copy(candidates, poll.Candidates)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return counts[candidates[i].PlaceID] > counts[candidates[j].PlaceID]
+	})
+	str := fmt.Sprintf("投票状況 (%d票)", len(poll.Votes))
+	for _, c := range candidates {
+		if n := counts[c.PlaceID]; n > 0 {
+			str += fmt.Sprintf("\n%d票: %s", n, c.Name)
+		}
+	}
+	str += fmt.Sprintf("\n締切: %s", poll.Deadline.In(jst).Format("15:04"))
+	return str
+}
+
+// 受付中の投票があるときの案内
+const pollOpenText = "受付中の投票があります\n「投票を締め切る」で締め切ってから始めてください"
+
+// 検索結果から投票を始める
+func (bot *Bot) StartPoll(ctx context.Context, event *linebot.Event, q *Query) {
+	scope := NewScope(event.Source)
+	// 検索する前に受付中の投票がないか確かめる
+	current := Poll{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &current, scope.Key(), nil); err == nil && current.Open(time.Now()) {
+		bot.ReplyMessage(ctx, event, TextMessage(pollOpenText))
+		return
+	}
+	p, err := bot.NearbySearch(q)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("投票の作成に失敗しました..."))
+		return
+	}
+	if len(*p) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("見つかりませんでした(´・ω・`)"))
+		return
+	}
+	candidates := *p
+	if len(candidates) > MaxPlaces {
+		candidates = candidates[:MaxPlaces]
+	}
+	poll := NewPoll(candidates, scope.ID, time.Now())
+	// 同時に始められても受付中の投票を上書きしないようにトランザクションで保存する
+	key := poll.NameKey(scope.Key(), nil)
+	_, err = bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		current := Poll{}
+		err := tx.Get(key, &current)
+		if err == nil && current.Open(time.Now()) {
+			return errPollOpen
+		} else if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		poll.Touch(time.Now())
+		_, err = tx.Put(key, &poll)
+		return err
+	})
+	if err == errPollOpen {
+		bot.ReplyMessage(ctx, event, TextMessage(pollOpenText))
+		return
+	} else if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("投票の作成に失敗しました..."))
+		return
+	}
+	text := fmt.Sprintf("投票を始めました!\n締切は%sです\n「投票を締め切る」で早めに締め切れます", poll.Deadline.In(jst).Format("15:04"))
+	if note, ok := truncatedSearchText(q); ok {
+		text += "\n" + note
+	}
+	pollPlaces := PollPlaces{Poll: &poll}
+	bot.ReplyMessage(ctx, event, TextMessage(text), CarouselMessage(&pollPlaces, MaxPlaces))
+}
+
+var (
+	errPollClosed   = errors.New("bot: poll is closed")
+	errPollOpen     = errors.New("bot: another poll is open")
+	errNotCandidate = errors.New("bot: place is not a candidate of the poll")
+)
+
+// 投票を受け付ける．同時に投票されても票を失わないようにトランザクションで更新する
+func (bot *Bot) VotePlace(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
+	// 1人1票にするためユーザIDが要る
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	scope := NewScope(event.Source)
+	key := (&Poll{}).NameKey(scope.Key(), nil)
+	poll := Poll{}
+	expired := false
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		expired = false
+		if err := tx.Get(key, &poll); err != nil {
+			return err
+		}
+		if poll.Closed {
+			return errPollClosed
+		}
+		now := time.Now()
+		if poll.Expired(now) {
+			expired = true
+			return nil
+		}
+		if !poll.HasCandidate(info.PlaceID) {
+			return errNotCandidate
+		}
+		poll.Vote(userID, info.PlaceID)
+		poll.Touch(now)
+		_, err := tx.Put(key, &poll)
+		return err
+	})
+	switch {
+	case err == datastore.ErrNoSuchEntity || err == errPollClosed:
+		bot.ReplyMessage(ctx, event, TextMessage("投票は締め切られています"))
+	case err == errNotCandidate:
+		bot.ReplyMessage(ctx, event, TextMessage("このお店は今の投票の候補ではありません"))
+	case err != nil:
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("投票に失敗しました..."))
+	case expired:
+		// 締切を過ぎていれば投票は受け付けずに結果を発表する
+		bot.ClosePoll(ctx, event)
+	default:
+		bot.ReplyMessage(ctx, event, TextMessage(poll.Standings()))
+	}
+}
+
+// 投票状況を表示する
+func (bot *Bot) ShowPoll(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	poll := Poll{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &poll, scope.Key(), nil); err != nil || poll.Closed {
+		bot.ReplyMessage(ctx, event, TextMessage("受付中の投票はありません"))
+		return
+	}
+	if poll.Expired(time.Now()) {
+		bot.ClosePoll(ctx, event)
+		return
+	}
+	bot.ReplyMessage(ctx, event, TextMessage(poll.Standings()))
+}
+
+// 投票を締め切る．締め切ったのがこの呼び出しでなければ(ほかで締め切り済みなら) closed は false
+func (bot *Bot) closePoll(ctx context.Context, key *datastore.Key) (poll *Poll, closed bool, err error) {
+	poll = &Poll{}
+	_, err = bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		closed = false
+		if err := tx.Get(key, poll); err != nil {
+			return err
+		}
+		if poll.Closed {
+			return nil
+		}
+		poll.Closed = true
+		poll.OpenUntil = time.Time{}
+		poll.Touch(time.Now())
+		if _, err := tx.Put(key, poll); err != nil {
+			return err
+		}
+		closed = true
+		return nil
+	})
+	return poll, closed, err
+}
+
+// 投票の結果
+func PollResultMessages(poll *Poll) []linebot.SendingMessage {
+	winners := poll.Winners()
+	if len(winners) == 0 {
+		return []linebot.SendingMessage{TextMessage("投票を締め切りました\n投票はありませんでした(´・ω・`)")}
+	}
+	text := fmt.Sprintf("投票を締め切りました!\n%d票で決定です", poll.Counts()[winners[0].PlaceID])
+	if len(winners) > 1 {
+		text = fmt.Sprintf("投票を締め切りました!\n%d票で同点です", poll.Counts()[winners[0].PlaceID])
+	}
+	winnerPlaces := NearbyPlaces(winners)
+	return []linebot.SendingMessage{TextMessage(text), CarouselMessage(&winnerPlaces, MaxPlaces)}
+}
+
+// 投票を締め切って結果を発表する
+func (bot *Bot) ClosePoll(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	poll, closed, err := bot.closePoll(ctx, (&Poll{}).NameKey(scope.Key(), nil))
+	if err == datastore.ErrNoSuchEntity || (err == nil && !closed) {
+		bot.ReplyMessage(ctx, event, TextMessage("受付中の投票はありません"))
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("投票の締め切りに失敗しました..."))
+		return
+	}
+	bot.ReplyMessage(ctx, event, PollResultMessages(poll)...)
+}
+
+// 締切を過ぎた投票を締め切った結果
+type ClosePollsResult struct {
+	Closed int `json:"closed"`
+	Failed int `json:"failed"`
+}
+
+// 締切を過ぎたまま誰も操作していない投票を締め切ってグループに結果を知らせる
+func (bot *Bot) ClosePolls(ctx context.Context) (*ClosePollsResult, error) {
+	result := &ClosePollsResult{}
+	q := datastore.NewQuery("Poll").Filter("open_until <", time.Now()).KeysOnly()
+	keys, err := bot.DatastoreClient.GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		poll, closed, err := bot.closePoll(ctx, key)
+		if err != nil {
+			log.Print(err)
+			result.Failed++
+			continue
+		}
+		// 締切の後にグループで締め切られていた
+		if !closed {
+			continue
+		}
+		result.Closed++
+		if poll.To == "" {
+			continue
+		}
+		if err := bot.PushMessage(ctx, poll.To, PollResultMessages(poll)...); err != nil {
+			log.Print(err)
+		}
+	}
+	return result, nil
+}
+
+// Cloud Schedulerから数分ごとに実行する
+func (bot *Bot) ClosePollsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bot.acceptJob(w, r) {
+			return
+		}
+		result, err := bot.ClosePolls(r.Context())
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
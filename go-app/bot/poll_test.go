@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func savePoll(t *testing.T, bot *Bot, groupID string, poll *Poll) {
+	t.Helper()
+	scope := Scope{Type: ScopeTypeGroup, ID: groupID}
+	if err := mystore.Save(context.Background(), bot.DatastoreClient, poll, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadPoll(t *testing.T, bot *Bot, groupID string) Poll {
+	t.Helper()
+	scope := Scope{Type: ScopeTypeGroup, ID: groupID}
+	poll := Poll{}
+	if err := mystore.Get(context.Background(), bot.DatastoreClient, &poll, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return poll
+}
+
+func testCandidates() places.Places {
+	return places.Places{
+		{PlaceID: "p1", Name: "ラーメン屋"},
+		{PlaceID: "p2", Name: "カレー屋"},
+	}
+}
+
+func TestVotePlaceConcurrent(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	poll := NewPoll(testCandidates(), "G1", time.Now())
+	savePoll(t, bot, "G1", &poll)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			event := groupEvent("G1", fmt.Sprintf("U%d", i), fmt.Sprintf("vote%d", i))
+			bot.VotePlace(ctx, event, &PlaceInfo{PlaceID: "p1"})
+		}(i)
+	}
+	wg.Wait()
+
+	// 受け付けたと返信した票はすべて残っている
+	accepted := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		texts := line.ReplyTexts(fmt.Sprintf("vote%d", i))
+		if len(texts) == 1 && texts[0] != "投票に失敗しました..." {
+			accepted[fmt.Sprintf("U%d", i)] = true
+		}
+	}
+	if len(accepted) == 0 {
+		t.Fatal("no vote is accepted")
+	}
+	voted := map[string]bool{}
+	for _, v := range loadPoll(t, bot, "G1").Votes {
+		voted[v.UserID] = true
+	}
+	if !reflect.DeepEqual(voted, accepted) {
+		t.Errorf("votes = %v, want %v", voted, accepted)
+	}
+}
+
+func TestVotePlaceWithoutUserID(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	poll := NewPoll(testCandidates(), "G1", time.Now())
+	savePoll(t, bot, "G1", &poll)
+
+	bot.VotePlace(ctx, groupEvent("G1", "", "vote"), &PlaceInfo{PlaceID: "p1"})
+	if got := line.ReplyTexts("vote"); !reflect.DeepEqual(got, []string{noUserIDText}) {
+		t.Errorf("reply = %q, want %q", got, noUserIDText)
+	}
+	if votes := loadPoll(t, bot, "G1").Votes; len(votes) != 0 {
+		t.Errorf("votes = %v, want none", votes)
+	}
+}
+
+func TestClosePolls(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	expired := NewPoll(testCandidates(), "G1", time.Now().Add(-2*PollDuration))
+	expired.Vote("U1", "p2")
+	savePoll(t, bot, "G1", &expired)
+	open := NewPoll(testCandidates(), "G2", time.Now())
+	savePoll(t, bot, "G2", &open)
+
+	result, err := bot.ClosePolls(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Closed != 1 || result.Failed != 0 {
+		t.Errorf("ClosePolls() = %+v, want 1 closed", result)
+	}
+	if got := loadPoll(t, bot, "G1"); !got.Closed {
+		t.Error("expired poll is not closed")
+	}
+	if got := loadPoll(t, bot, "G2"); got.Closed {
+		t.Error("open poll is closed")
+	}
+	if n := len(line.Pushes("G1")); n != 2 {
+		t.Errorf("pushed %d messages to the group, want the result text and carousel", n)
+	}
+
+	// 締め切った投票は二度発表しない
+	result, err = bot.ClosePolls(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Closed != 0 {
+		t.Errorf("second ClosePolls() = %+v, want none closed", result)
+	}
+	bot.ClosePoll(ctx, groupEvent("G1", "U1", "close"))
+	if got := line.ReplyTexts("close"); !reflect.DeepEqual(got, []string{"受付中の投票はありません"}) {
+		t.Errorf("reply = %q, want no open poll", got)
+	}
+}
+
+func TestStartPollWhileOpen(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	poll := NewPoll(testCandidates(), "G1", time.Now())
+	poll.Vote("U1", "p1")
+	savePoll(t, bot, "G1", &poll)
+
+	// 受付中の投票は置き換えない(検索もしない)
+	bot.StartPoll(ctx, groupEvent("G1", "U2", "start"), &Query{Lat: "35", Lng: "139"})
+	if got := line.ReplyTexts("start"); !reflect.DeepEqual(got, []string{pollOpenText}) {
+		t.Errorf("reply = %q, want %q", got, pollOpenText)
+	}
+	if votes := loadPoll(t, bot, "G1").Votes; len(votes) != 1 {
+		t.Errorf("votes = %v, want the vote kept", votes)
+	}
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ボタンのデータの有効期間．後から見返したお気に入りのボタンも押せるように長めにする
+const PostbackStateTTL = 7 * 24 * time.Hour
+
+// 期限切れのボタンが押されたときの案内
+const stalePostbackText = "このボタンは有効期限が切れています\nお手数ですがもう一度検索や表示からやり直してください"
+
+// ボタンのデータ．ボタンにはトークンだけを埋め込み，データはサーバに保存する
+// (ポストバックのデータは300文字までなので，キーワードの多い検索条件などが入りきらない)
+type PostbackState struct {
+	Action    PostbackAction `datastore:"action,noindex"`
+	Data      string         `datastore:"data,noindex"`
+	ExpiresAt time.Time      `datastore:"expires_at"`
+	mystore.Timestamp
+}
+
+func (s *PostbackState) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	return datastore.NameKey("PostbackState", name, parent)
+}
+
+// 期限を過ぎたか
+func (s *PostbackState) Expired(now time.Time) bool {
+	return now.After(s.ExpiresAt)
+}
+
+// 操作とデータから決まる短いトークン
+func postbackToken(action PostbackAction, data []byte) string {
+	return mystore.HashedString(string(action) + ":" + string(data))[:16]
+}
+
+// ポストバックのデータの上限(文字数)
+const MaxPostbackDataLength = 300
+
+// 送信前のボタンのデータ．PostbackJSON はトークンとデータの両方を埋め込んでおき，
+// 送信するときにデータを保存してトークンだけにする
+type pendingPostback struct {
+	Action PostbackAction  `json:"action"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Token  string          `json:"token,omitempty"`
+}
+
+func messageJSON(m linebot.SendingMessage) (interface{}, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// トークンとデータを埋め込んだボタンごとに f を呼ぶ
+func walkPostbacks(v interface{}, f func(action map[string]interface{}, pb *pendingPostback)) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v["type"] == "postback" {
+			if data, ok := v["data"].(string); ok {
+				pb := pendingPostback{}
+				if err := json.Unmarshal([]byte(data), &pb); err == nil && pb.Token != "" && len(pb.Data) > 0 {
+					f(v, &pb)
+				}
+			}
+		}
+		for _, e := range v {
+			walkPostbacks(e, f)
+		}
+	case []interface{}:
+		for _, e := range v {
+			walkPostbacks(e, f)
+		}
+	}
+}
+
+// 送信前のボタンのデータを rewrite の返す文字列に書き換える
+func rewritePostbacks(messages []linebot.SendingMessage, rewrite func(pb *pendingPostback) string) []linebot.SendingMessage {
+	rewritten := make([]linebot.SendingMessage, len(messages))
+	for i, m := range messages {
+		rewritten[i] = m
+		v, err := messageJSON(m)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		changed := false
+		walkPostbacks(v, func(action map[string]interface{}, pb *pendingPostback) {
+			action["data"] = rewrite(pb)
+			changed = true
+		})
+		if !changed {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		rewritten[i] = rawMessage(b)
+	}
+	return rewritten
+}
+
+func postbackString(pb pendingPostback) string {
+	b, _ := json.Marshal(&pb)
+	return string(b)
+}
+
+// 送信するメッセージのボタンのデータをすべて保存し(保存済みなら期限を延ばす)，ボタンをトークンだけにする．
+// 保存するデータは送信するメッセージから集めるので，同時に処理している返信には左右されない
+func (bot *Bot) savePostbackStates(ctx context.Context, messages []linebot.SendingMessage) ([]linebot.SendingMessage, error) {
+	now := time.Now()
+	keys := []*datastore.Key{}
+	states := []*PostbackState{}
+	seen := map[string]bool{}
+	rewritten := rewritePostbacks(messages, func(pb *pendingPostback) string {
+		// 同じキーは1回の保存に重ねられない
+		if !seen[pb.Token] {
+			seen[pb.Token] = true
+			s := PostbackState{
+				Action:    pb.Action,
+				Data:      string(pb.Data),
+				ExpiresAt: now.Add(PostbackStateTTL),
+			}
+			s.Touch(now)
+			keys = append(keys, s.NameKey(pb.Token, nil))
+			states = append(states, &s)
+		}
+		return postbackString(pendingPostback{Action: pb.Action, Token: pb.Token})
+	})
+	if len(keys) == 0 {
+		return messages, nil
+	}
+	if _, err := bot.DatastoreClient.PutMulti(ctx, keys, states); err != nil {
+		return nil, err
+	}
+	return rewritten, nil
+}
+
+// 送信する前にボタンのデータを保存する．
+// 保存できなければ，上限に収まるボタンはトークンの代わりにデータをそのまま埋め込む
+func (bot *Bot) prepareMessages(ctx context.Context, messages []linebot.SendingMessage) []linebot.SendingMessage {
+	prepared, err := bot.savePostbackStates(ctx, messages)
+	if err != nil {
+		log.Print(err)
+		return inlinePostbacks(messages)
+	}
+	return prepared
+}
+
+// 送信前のボタンをデータを埋め込んだボタンに書き換える．
+// 上限を超えるものはトークンだけにする(押されたときは期限切れとして案内する)
+func inlinePostbacks(messages []linebot.SendingMessage) []linebot.SendingMessage {
+	return rewritePostbacks(messages, func(pb *pendingPostback) string {
+		inlined := postbackString(pendingPostback{Action: pb.Action, Data: pb.Data})
+		if utf8.RuneCountInString(inlined) > MaxPostbackDataLength {
+			log.Print("postback data is too long to inline: ", pb.Action)
+			return postbackString(pendingPostback{Action: pb.Action, Token: pb.Token})
+		}
+		return inlined
+	})
+}
+
+// JSONを書き換えたメッセージ
+type rawMessage json.RawMessage
+
+func (m rawMessage) Message() {}
+
+func (m rawMessage) MarshalJSON() ([]byte, error) {
+	return m, nil
+}
+
+func (m rawMessage) WithQuickReplies(*linebot.QuickReplyItems) linebot.SendingMessage {
+	return m
+}
+
+func (m rawMessage) WithSender(*linebot.Sender) linebot.SendingMessage {
+	return m
+}
+
+func (m rawMessage) AddEmoji(*linebot.Emoji) linebot.SendingMessage {
+	return m
+}
+
+// トークンから保存したデータを読み込む．期限切れならないものとして扱う
+func (bot *Bot) loadPostbackState(ctx context.Context, postback *Postback) error {
+	s := PostbackState{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &s, postback.Token, nil); err != nil {
+		return err
+	}
+	if s.Expired(time.Now()) || s.Action != postback.Action {
+		return datastore.ErrNoSuchEntity
+	}
+	data, err := router.decodePostbackData(s.Action, json.RawMessage(s.Data))
+	if err != nil {
+		return err
+	}
+	postback.Data = data
+	return nil
+}
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 返信したメッセージのボタンのデータ
+func replyPostbackData(t *testing.T, line *fakeLINE, replyToken string) []string {
+	t.Helper()
+	data := []string{}
+	for _, m := range line.Reply(replyToken) {
+		var v interface{}
+		if err := json.Unmarshal(m, &v); err != nil {
+			t.Fatal(err)
+		}
+		collectPostbackData(v, &data)
+	}
+	return data
+}
+
+func collectPostbackData(v interface{}, data *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v["type"] == "postback" {
+			*data = append(*data, v["data"].(string))
+		}
+		for _, e := range v {
+			collectPostbackData(e, data)
+		}
+	case []interface{}:
+		for _, e := range v {
+			collectPostbackData(e, data)
+		}
+	}
+}
+
+func buttonMessage(data string) linebot.SendingMessage {
+	button := linebot.NewQuickReplyButton("", linebot.NewPostbackAction("キーワード", data, "", ""))
+	return TextMessage("ボタン").WithQuickReplies(linebot.NewQuickReplyItems(button))
+}
+
+func savedQuery(t *testing.T, bot *Bot, scopeKey string) Query {
+	t.Helper()
+	q := Query{}
+	if err := mystore.Get(context.Background(), bot.DatastoreClient, &q, scopeKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestPostbackRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+
+	q := NewQuery("35.658034", "139.701636")
+	for i := 0; i < 20; i++ {
+		q.Keywords = append(q.Keywords, fmt.Sprintf("長めのキーワード%02d", i))
+	}
+	data := PostbackJSON(PostbackActionChangeKeyword, &q)
+
+	bot.ReplyMessage(ctx, userEvent("U1", "reply"), buttonMessage(data))
+	sent := replyPostbackData(t, line, "reply")
+	if len(sent) != 1 {
+		t.Fatalf("sent postback data = %v, want 1 button", sent)
+	}
+	if n := utf8.RuneCountInString(sent[0]); n > MaxPostbackDataLength {
+		t.Fatalf("postback data has %d characters, want at most %d", n, MaxPostbackDataLength)
+	}
+	if strings.Contains(sent[0], "キーワード") || !strings.Contains(sent[0], `"token"`) {
+		t.Fatalf("postback data %s embeds the query", sent[0])
+	}
+
+	bot.HandlePostback(ctx, postbackEvent("U1", "tap", sent[0]))
+	for _, text := range line.ReplyTexts("tap") {
+		if text == stalePostbackText {
+			t.Fatal("fresh button is treated as stale")
+		}
+	}
+	if got := savedQuery(t, bot, "U1"); !reflect.DeepEqual(got.Keywords, q.Keywords) {
+		t.Errorf("keywords = %v, want %v", got.Keywords, q.Keywords)
+	}
+}
+
+func TestPostbackStale(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+
+	expired := PostbackState{
+		Action:    PostbackActionChangeKeyword,
+		Data:      `{"lat":"35","lng":"139","keywords":[]}`,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &expired, "expiredtoken0000", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown token", `{"action":"changeKeyword","token":"unknowntoken0000"}`},
+		{"expired", `{"action":"changeKeyword","token":"expiredtoken0000"}`},
+		{"other action", `{"action":"changeRadius","token":"expiredtoken0000"}`},
+	}
+	for _, tt := range tests {
+		bot.HandlePostback(ctx, postbackEvent("U1", tt.name, tt.data))
+		if got := line.ReplyTexts(tt.name); !reflect.DeepEqual(got, []string{stalePostbackText}) {
+			t.Errorf("%s: reply = %q, want %q", tt.name, got, stalePostbackText)
+		}
+	}
+}
+
+func TestPostbackInlineOnSaveFailure(t *testing.T) {
+	ctx := context.Background()
+	bot, ds, line := newTestBot(t)
+
+	q := NewQuery("35.681236", "139.767125")
+	q.Keywords = []string{"保存できないときのラーメン"}
+	data := PostbackJSON(PostbackActionChangeKeyword, &q)
+
+	ds.FailCommit = true
+	bot.ReplyMessage(ctx, userEvent("U1", "reply"), buttonMessage(data))
+	ds.FailCommit = false
+	sent := replyPostbackData(t, line, "reply")
+	if len(sent) != 1 {
+		t.Fatalf("sent postback data = %v, want 1 button", sent)
+	}
+	if !strings.Contains(sent[0], `"data"`) || strings.Contains(sent[0], `"token"`) {
+		t.Fatalf("sent postback data = %s, want the query inlined", sent[0])
+	}
+
+	bot.HandlePostback(ctx, postbackEvent("U1", "tap", sent[0]))
+	if got := savedQuery(t, bot, "U1"); !reflect.DeepEqual(got.Keywords, q.Keywords) {
+		t.Errorf("keywords = %v, want %v", got.Keywords, q.Keywords)
+	}
+}
+
+// 同じボタンを送る返信は，ほかの返信で保存に失敗しても自分で保存し，期限も延ばす
+func TestPostbackSameButtonInAnotherReply(t *testing.T) {
+	ctx := context.Background()
+	bot, ds, line := newTestBot(t)
+
+	q := NewQuery("35.681236", "139.767125")
+	q.Keywords = []string{"ラーメン"}
+	data := PostbackJSON(PostbackActionChangeKeyword, &q)
+	pb := pendingPostback{}
+	if err := json.Unmarshal([]byte(data), &pb); err != nil {
+		t.Fatal(err)
+	}
+	soon := PostbackState{Action: pb.Action, Data: string(pb.Data), ExpiresAt: time.Now().Add(time.Minute)}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &soon, pb.Token, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ds.FailCommit = true
+	bot.ReplyMessage(ctx, userEvent("U1", "failed"), buttonMessage(data))
+	ds.FailCommit = false
+	bot.ReplyMessage(ctx, userEvent("U2", "reply"), buttonMessage(data))
+
+	s := PostbackState{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &s, pb.Token, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.Expired(time.Now().Add(PostbackStateTTL - time.Hour)) {
+		t.Errorf("expires at %v, want it extended", s.ExpiresAt)
+	}
+	sent := replyPostbackData(t, line, "reply")
+	if len(sent) != 1 {
+		t.Fatalf("sent postback data = %v, want 1 button", sent)
+	}
+	bot.HandlePostback(ctx, postbackEvent("U2", "tap", sent[0]))
+	if got := savedQuery(t, bot, "U2"); !reflect.DeepEqual(got.Keywords, q.Keywords) {
+		t.Errorf("keywords = %v, want %v", got.Keywords, q.Keywords)
+	}
+}
@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/recommend"
+)
+
+// 検索やお気に入りから学習したユーザの好み
+type Preference struct {
+	recommend.Preference
+	mystore.Timestamp
+}
+
+func (pref *Preference) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("Preference", name, parent)
+}
+
+// 学習の重み
+const (
+	favoriteLearnWeight = 2
+	visitLearnWeight    = 1
+)
+
+func (bot *Bot) getPreference(ctx context.Context, userID string) (*Preference, error) {
+	pref := Preference{}
+	if userID == "" {
+		return &pref, nil
+	}
+	err := mystore.Get(ctx, bot.DatastoreClient, &pref, userID, nil)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return &pref, nil
+}
+
+// 好みを更新する．失敗しても返信には影響させない
+func (bot *Bot) learnPreference(ctx context.Context, userID string, learn func(pref *recommend.Preference)) {
+	if userID == "" {
+		return
+	}
+	pref, err := bot.getPreference(ctx, userID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	learn(&pref.Preference)
+	if err := mystore.Save(ctx, bot.DatastoreClient, pref, userID, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// 検索結果をユーザの好みで並べ替えて，上位に「あなたへのおすすめ」の理由をつける
+func (bot *Bot) Recommend(ctx context.Context, userID string, p places.Places) {
+	pref, err := bot.getPreference(ctx, userID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	pref.Rank(p)
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+// お気に入り更新の結果
+type RefreshResult struct {
+	Lists     int `json:"lists"`
+	Places    int `json:"places"`
+	Updated   int `json:"updated"`
+	Rewritten int `json:"rewritten"`
+	Closed    int `json:"closed"`
+	Failed    int `json:"failed"`
+}
+
+// お店のリストを持つエンティティ
+type favoriteList interface {
+	mystore.Entity
+	mystore.Touchable
+	favoriteItems() []FavoriteItem
+}
+
+func (f *Favorite) favoriteItems() []FavoriteItem {
+	return f.List
+}
+
+func (shared *SharedFavorite) favoriteItems() []FavoriteItem {
+	return shared.List
+}
+
+// 保存済みのお店を最新の情報で書き換える．
+// place IDが変わっていれば新しいものにし，ユーザがつけた情報はそのまま残す
+func applyRefreshedPlace(item *FavoriteItem, p *places.Place) (changed, rewritten, closed bool) {
+	old := item.Place
+	rewritten = p.PlaceID != old.PlaceID
+	closed = p.Closed() && !old.Closed()
+
+	item.PlaceID = p.PlaceID
+	item.Name = p.Name
+	item.Rating = p.Rating
+	item.Address = p.Address
+	item.Lat = p.Lat
+	item.Lng = p.Lng
+	item.BusinessStatus = p.BusinessStatus
+	item.Types = p.Types
+	item.PriceLevel = p.PriceLevel
+	// 写真の取得に失敗したときは代替画像になるので元の写真を残す
+	if p.PhotoURI != "" && p.PhotoURI != places.AlternativePhotoURI() {
+		item.PhotoURI = p.PhotoURI
+	}
+	if item.GooglemapURI == "" {
+		item.GooglemapURI = p.GooglemapURI
+	}
+	changed = !reflect.DeepEqual(item.Place, old)
+	return
+}
+
+// すべてのお気に入りと共有リストのお店を更新する
+func (bot *Bot) RefreshFavorites(ctx context.Context, notify bool) (*RefreshResult, error) {
+	result := RefreshResult{}
+	// 同じお店は1回だけ問い合わせる．失敗したお店はnil
+	cache := map[string]*places.Place{}
+	refresh := func(placeID string) {
+		if _, ok := cache[placeID]; ok {
+			return
+		}
+		p, err := bot.DetailsSearchWithPhoto(placeID)
+		if err != nil {
+			log.Print(placeID, err)
+			result.Failed++
+		}
+		cache[placeID] = p
+	}
+
+	kinds := map[string]func() favoriteList{
+		"Favorite":       func() favoriteList { return &Favorite{} },
+		"SharedFavorite": func() favoriteList { return &SharedFavorite{} },
+	}
+	for kind, newList := range kinds {
+		keys, err := bot.DatastoreClient.GetAll(ctx, datastore.NewQuery(kind).KeysOnly(), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			l := newList()
+			if err := bot.DatastoreClient.Get(ctx, key, l); err != nil {
+				log.Print(err)
+				continue
+			}
+			for _, item := range l.favoriteItems() {
+				refresh(item.PlaceID)
+			}
+			closed, err := bot.applyRefreshedPlaces(ctx, key, newList, cache, &result)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			result.Lists++
+			if f, ok := l.(*Favorite); ok && notify && len(closed) > 0 {
+				bot.notifyClosedFavorites(ctx, f.UserID, closed)
+			}
+		}
+	}
+	result.Places = len(cache)
+	return &result, nil
+}
+
+// 取得したお店の情報をトランザクション内で反映する．閉業したお店の名前を返す
+func (bot *Bot) applyRefreshedPlaces(ctx context.Context, key *datastore.Key, newList func() favoriteList, cache map[string]*places.Place, result *RefreshResult) ([]string, error) {
+	var closed []string
+	var updated, rewritten int
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		// 再試行されたときのために数え直す
+		closed, updated, rewritten = nil, 0, 0
+		l := newList()
+		if err := tx.Get(key, l); err != nil {
+			return err
+		}
+		items := l.favoriteItems()
+		for i := range items {
+			p := cache[items[i].PlaceID]
+			if p == nil {
+				continue
+			}
+			changed, r, c := applyRefreshedPlace(&items[i], p)
+			if changed {
+				updated++
+			}
+			if r {
+				rewritten++
+			}
+			if c {
+				closed = append(closed, p.Name)
+			}
+		}
+		if updated == 0 {
+			return nil
+		}
+		l.Touch(time.Now())
+		_, err := tx.Put(key, l)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Updated += updated
+	result.Rewritten += rewritten
+	result.Closed += len(closed)
+	return closed, nil
+}
+
+// 閉業したお気に入りをユーザに知らせる
+func (bot *Bot) notifyClosedFavorites(ctx context.Context, userID string, names []string) {
+	if userID == "" {
+		return
+	}
+	text := "お気に入りの「" + strings.Join(names, "」「") + "」は閉業したようです"
+	if err := bot.PushMessage(ctx, userID, TextMessage(text)); err != nil {
+		log.Print(err)
+	}
+}
+
+// Cloud Schedulerから定期実行する．?notify=true で閉業したお店を通知する
+func (bot *Bot) RefreshFavoritesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bot.acceptJob(w, r) {
+			return
+		}
+		notify := r.URL.Query().Get("notify") == "true"
+		result, err := bot.RefreshFavorites(r.Context(), notify)
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
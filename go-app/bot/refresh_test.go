@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func TestJobHandlersRequirePost(t *testing.T) {
+	bot := &Bot{JobToken: "job-token"}
+	handlers := map[string]http.HandlerFunc{
+		"refresh-favorites": bot.RefreshFavoritesHandler(),
+		"cleanup":           bot.CleanupHandler(),
+		"close-polls":       bot.ClosePollsHandler(),
+	}
+	tests := []struct {
+		method string
+		token  string
+		want   int
+	}{
+		{http.MethodGet, "job-token", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "other", http.StatusUnauthorized},
+	}
+	for name, h := range handlers {
+		for _, tt := range tests {
+			r := httptest.NewRequest(tt.method, "/jobs/"+name, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s %s with %q: status = %d, want %d", tt.method, name, tt.token, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestGetFavoriteBackfillsUserID(t *testing.T) {
+	ctx := context.Background()
+	bot, _, _ := newTestBot(t)
+	// ユーザIDを記録する前に保存されたリスト
+	legacy := Favorite{List: []FavoriteItem{{Place: places.Place{PlaceID: "p1", Name: "ラーメン屋"}}}}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &legacy, "U1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := bot.getFavorite(ctx, "U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.UserID != "U1" || len(f.List) != 1 {
+		t.Errorf("getFavorite() = %+v, want the list with user ID", f)
+	}
+	saved := Favorite{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &saved, "U1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if saved.UserID != "U1" || len(saved.List) != 1 {
+		t.Errorf("saved favorite = %+v, want the list with user ID", saved)
+	}
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/richmenu"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// NewLINEClient creates a Messaging API client.
+// endpoint が空でなければそちらに向ける(代役サーバなど)
+func NewLINEClient(channelSecret, channelToken, endpoint string) (*linebot.Client, error) {
+	options := []linebot.ClientOption{}
+	if endpoint != "" {
+		options = append(options,
+			linebot.WithEndpointBase(endpoint),
+			linebot.WithEndpointBaseData(endpoint),
+		)
+	}
+	return linebot.New(channelSecret, channelToken, options...)
+}
+
+// LoadRichMenus reads the rich menu IDs created by cmd/richmenu.
+// 作成・更新はインスタンスごとに競合しないように cmd/richmenu だけで行う．
+// 失敗してもメニューを切り替えないだけなので起動は続ける
+func (bot *Bot) LoadRichMenus(ctx context.Context) {
+	ids, err := richmenu.Lookup(ctx, bot.LINEBotClient)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	log.Println("[RichMenu]", ids)
+	bot.RichMenus = ids
+}
+
+// 検索中のメニューをリンクしたユーザ．
+// 検索条件が期限切れになっても切り替わらないので，掃除ジョブで期限を過ぎたものを元に戻す
+type RichMenuLink struct {
+	UserID    string    `datastore:"user_id,noindex"`
+	ExpiresAt time.Time `datastore:"expires_at"`
+	mystore.Timestamp
+}
+
+func (l *RichMenuLink) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("RichMenuLink", name, parent)
+}
+
+// 1対1のトークでユーザのリッチメニューを切り替える．
+// デフォルトのメニューに戻すときはリンクを外す
+func (bot *Bot) SwitchRichMenu(ctx context.Context, event *linebot.Event, kind string) {
+	scope := NewScope(event.Source)
+	if scope.IsGroup() || scope.UserID == "" {
+		return
+	}
+	if kind == richmenu.Default {
+		if _, err := bot.LINEBotClient.UnlinkUserRichMenu(scope.UserID).WithContext(ctx).Do(); err != nil {
+			log.Print(err)
+		}
+		if err := mystore.Delete(ctx, bot.DatastoreClient, &RichMenuLink{}, scope.UserID, nil); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	id, ok := bot.RichMenus[kind]
+	if !ok {
+		return
+	}
+	if _, err := bot.LINEBotClient.LinkUserRichMenu(scope.UserID, id).WithContext(ctx).Do(); err != nil {
+		log.Print(err)
+		return
+	}
+	link := RichMenuLink{UserID: scope.UserID, ExpiresAt: time.Now().Add(QueryTTL)}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &link, scope.UserID, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// 期限を過ぎたリンクを外してデフォルトのメニューに戻す．戻したユーザ数を返す
+func (bot *Bot) unlinkExpiredRichMenus(ctx context.Context, now time.Time) (int, int, error) {
+	links := []*RichMenuLink{}
+	keys, err := bot.DatastoreClient.GetAll(ctx, datastore.NewQuery("RichMenuLink").Filter("expires_at <", now), &links)
+	if err != nil {
+		return 0, 0, err
+	}
+	done := []*datastore.Key{}
+	failed := 0
+	for i, l := range links {
+		if _, err := bot.LINEBotClient.UnlinkUserRichMenu(l.UserID).WithContext(ctx).Do(); err != nil {
+			log.Print(err)
+			failed++
+			continue
+		}
+		done = append(done, keys[i])
+	}
+	// 一度に削除できるのは500件まで
+	for start := 0; start < len(done); start += 500 {
+		end := start + 500
+		if end > len(done) {
+			end = len(done)
+		}
+		if err := bot.DatastoreClient.DeleteMulti(ctx, done[start:end]); err != nil {
+			log.Print(err)
+		}
+	}
+	return len(done), failed, nil
+}
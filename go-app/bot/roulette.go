@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ルーレットの条件
+type RouletteInfo struct {
+	// 検索結果のお店．もう一回のときも検索し直さずにこの中から選ぶ(空ならお気に入りから選ぶ)
+	Candidates places.Places `json:"candidates,omitempty"`
+	// 候補を持たない古いボタンの検索条件
+	Query *Query `json:"query,omitempty"`
+	// 前回選ばれたお店(もう一回のときに除く)
+	PlaceID string `json:"place_id,omitempty"`
+	// 最近行ったお店も候補にする
+	IncludeVisited bool `json:"include_visited,omitempty"`
+}
+
+// お気に入りから選ぶか
+func (r *RouletteInfo) FromFavorites() bool {
+	return len(r.Candidates) == 0 && r.Query == nil
+}
+
+func (r *RouletteInfo) PostbackData() {}
+
+// 評価が高いほど選ばれやすい重み
+func rouletteWeight(rating float64) float64 {
+	return 1 + rating*rating
+}
+
+// ルーレットで1件選ぶ
+// excludeに含まれるお店は除くが，全て除かれる場合は除かずに選ぶ
+func PickPlace(p places.Places, exclude map[string]bool, rnd *rand.Rand) (places.Place, bool) {
+	candidates := make(places.Places, 0, len(p))
+	for _, place := range p {
+		if !exclude[place.PlaceID] {
+			candidates = append(candidates, place)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p
+	}
+	if len(candidates) == 0 {
+		return places.Place{}, false
+	}
+
+	total := 0.0
+	for _, place := range candidates {
+		total += rouletteWeight(place.Rating)
+	}
+	x := rnd.Float64() * total
+	for _, place := range candidates {
+		x -= rouletteWeight(place.Rating)
+		if x < 0 {
+			return place, true
+		}
+	}
+	return candidates[len(candidates)-1], true
+}
+
+// ルーレットの候補を取得する
+func (bot *Bot) rouletteCandidates(ctx context.Context, event *linebot.Event, info *RouletteInfo) (places.Places, error) {
+	if len(info.Candidates) > 0 {
+		return info.Candidates, nil
+	}
+	if info.Query != nil {
+		p, err := bot.NearbySearch(info.Query)
+		if err != nil {
+			return nil, err
+		}
+		return *p, nil
+	}
+	// グループではグループの共有リストから選ぶ
+	scope := NewScope(event.Source)
+	if scope.IsGroup() {
+		shared := SharedFavorite{}
+		err := mystore.Get(ctx, bot.DatastoreClient, &shared, scope.Key(), nil)
+		if err == datastore.ErrNoSuchEntity {
+			return places.Places{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		p := make(places.Places, len(shared.List))
+		for i := range shared.List {
+			p[i] = shared.List[i].Place
+		}
+		return p, nil
+	}
+	userID := scope.UserID
+	f, err := bot.getFavorite(ctx, userID)
+	if err == datastore.ErrNoSuchEntity {
+		return places.Places{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p := make(places.Places, len(f.List))
+	for i := range f.List {
+		p[i] = f.List[i].Place
+	}
+	return p, nil
+}
+
+// ルーレットで選んだお店を表示
+func (bot *Bot) Roulette(ctx context.Context, event *linebot.Event, info *RouletteInfo) {
+	candidates, err := bot.rouletteCandidates(ctx, event, info)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("ルーレットに失敗しました..."))
+		return
+	}
+	// 指定がなければ最近行ったお店は選ばない
+	exclude := map[string]bool{}
+	if !info.IncludeVisited {
+		if l, err := bot.getVisitLog(ctx, NewScope(event.Source).UserID); err == nil {
+			exclude = l.VisitedSince(time.Now().Add(-RecentVisitPeriod))
+		}
+	}
+	if info.PlaceID != "" {
+		exclude[info.PlaceID] = true
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	place, ok := PickPlace(candidates, exclude, rnd)
+	if !ok {
+		bot.ReplyMessage(ctx, event, TextMessage("候補のお店がありません(´・ω・`)"))
+		return
+	}
+	// 古いボタンで検索し直したときは，次からはその結果から選ぶ
+	next := RouletteInfo{
+		Candidates:     candidates,
+		PlaceID:        place.PlaceID,
+		IncludeVisited: info.IncludeVisited,
+	}
+	if info.FromFavorites() {
+		next.Candidates = nil
+	}
+	bot.ReplyMessage(ctx, event, RouletteMessage(&place, info.FromFavorites(), &next))
+}
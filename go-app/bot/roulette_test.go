@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func TestRouletteFromCandidates(t *testing.T) {
+	ctx := context.Background()
+	candidates := places.Places{
+		{PlaceID: "p1", Name: "行ったラーメン屋"},
+		{PlaceID: "p2", Name: "初めてのカレー屋"},
+	}
+	tests := []struct {
+		name string
+		info RouletteInfo
+		want string
+	}{
+		// 最近行ったお店は除く
+		{"exclude visited", RouletteInfo{Candidates: candidates}, "初めてのカレー屋"},
+		// 含めるときは前回のお店だけを除く
+		{"include visited", RouletteInfo{Candidates: candidates, PlaceID: "p2", IncludeVisited: true}, "行ったラーメン屋"},
+	}
+	for _, tt := range tests {
+		// 検索し直すとPlaces APIを呼ぶので，APIキーのないボットでは失敗する
+		bot, _, line := newTestBot(t)
+		l := VisitLog{Visits: []Visit{{PlaceID: "p1", Name: "行ったラーメン屋", VisitedAt: time.Now()}}}
+		if err := mystore.Save(ctx, bot.DatastoreClient, &l, "U1", nil); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			token := fmt.Sprintf("%s%d", tt.name, i)
+			bot.Roulette(ctx, userEvent("U1", token), &tt.info)
+			replies := line.Reply(token)
+			if len(replies) != 1 || !strings.Contains(string(replies[0]), tt.want) {
+				t.Errorf("%s: reply = %s, want %s", tt.name, replies, tt.want)
+			}
+		}
+	}
+}
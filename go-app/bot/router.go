@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// コマンドを使える会話
+type CommandScope uint8
+
+const (
+	CommandScopeUser CommandScope = 1 << iota
+	CommandScopeGroup
+	// どこでも使える(ゼロ値)
+	CommandScopeAll CommandScope = 0
+)
+
+func (s CommandScope) allows(scope Scope) bool {
+	if s == CommandScopeAll {
+		return true
+	}
+	if scope.IsGroup() {
+		return s&CommandScopeGroup != 0
+	}
+	return s&CommandScopeUser != 0
+}
+
+// 使えない会話で送られたときの案内
+func (s CommandScope) rejection(name string) string {
+	if s&CommandScopeUser != 0 {
+		return "「" + name + "」はボットとの1対1のトークで使えます"
+	}
+	return "「" + name + "」はグループで使えます"
+}
+
+// テキストの一致のしかた
+type MatchType int
+
+const (
+	MatchExact MatchType = iota
+	MatchPrefix
+	MatchRegexp
+)
+
+// テキストのコマンドの処理．argは前方一致なら残りの部分，正規表現なら1つ目のグループ(なければ全体)
+type TextHandler func(bot *Bot, ctx context.Context, event *linebot.Event, arg string)
+
+// 引数を使わない処理をTextHandlerにする
+func noArg(f func(bot *Bot, ctx context.Context, event *linebot.Event)) TextHandler {
+	return func(bot *Bot, ctx context.Context, event *linebot.Event, arg string) {
+		f(bot, ctx, event)
+	}
+}
+
+// テキストのコマンド
+type TextCommand struct {
+	// 完全一致・前方一致ではそのテキスト，正規表現ではヘルプに表示する名前
+	Text    string
+	Match   MatchType
+	Pattern *regexp.Regexp
+	// ヘルプに表示する使い方(空ならText)と説明．説明が空ならヘルプに載せない
+	Usage string
+	Help  string
+	Scope CommandScope
+	// グループでも呼びかけが必要か(完全一致のみ．前方一致と正規表現は常に必要)
+	Mention bool
+	// 入力待ちを終えずに処理する
+	KeepsConversation bool
+	Handler           TextHandler
+}
+
+func (c *TextCommand) match(text string) (string, bool) {
+	switch c.Match {
+	case MatchExact:
+		return "", text == c.Text
+	case MatchPrefix:
+		if strings.HasPrefix(text, c.Text) {
+			return strings.TrimPrefix(text, c.Text), true
+		}
+	case MatchRegexp:
+		m := c.Pattern.FindStringSubmatch(text)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return m[1], true
+		}
+		return m[0], true
+	}
+	return "", false
+}
+
+// ボタンの操作の処理
+type PostbackHandler func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData)
+
+// ボタンの操作
+type PostbackRoute struct {
+	Action PostbackAction
+	// データの型．nilならQuery
+	NewData func() PostbackData
+	Scope   CommandScope
+	// 入力待ちを終えずに処理する
+	KeepsConversation bool
+	Handler           PostbackHandler
+}
+
+// テキストとボタンの操作を登録された処理に振り分ける
+type Router struct {
+	commands  []*TextCommand
+	postbacks map[PostbackAction]*PostbackRoute
+	// どのコマンドにも当てはまらないテキストの処理とそのヘルプ
+	Fallback     TextHandler
+	FallbackHelp string
+}
+
+func NewRouter() *Router {
+	return &Router{postbacks: map[PostbackAction]*PostbackRoute{}}
+}
+
+// テキストのコマンドを登録する．登録した順に一致を調べる
+func (r *Router) Text(c TextCommand) {
+	r.commands = append(r.commands, &c)
+}
+
+// ボタンの操作を登録する
+func (r *Router) Postback(p PostbackRoute) {
+	r.postbacks[p.Action] = &p
+}
+
+func (r *Router) exact(text string) *TextCommand {
+	for _, c := range r.commands {
+		if c.Match == MatchExact && c.Text == text {
+			return c
+		}
+	}
+	return nil
+}
+
+// HandleText routes the text message.
+// グループでは呼びかけの要らないコマンドか，呼びかけられたときだけ反応する
+func (r *Router) HandleText(bot *Bot, ctx context.Context, event *linebot.Event, text string) {
+	scope := NewScope(event.Source)
+	if scope.IsGroup() {
+		if c := r.exact(text); c == nil || c.Mention {
+			t, ok := bot.GroupTrigger(text)
+			if !ok {
+				return
+			}
+			// 呼びかけだけなら使い方を案内する
+			if t == "" {
+				bot.ReplyMessage(ctx, event, TextMessage(r.Help(scope)))
+				return
+			}
+			text = t
+		}
+	}
+	for _, c := range r.commands {
+		arg, ok := c.match(text)
+		if !ok {
+			continue
+		}
+		if !c.Scope.allows(scope) {
+			bot.ReplyMessage(ctx, event, TextMessage(c.Scope.rejection(c.Text)))
+			return
+		}
+		if !c.KeepsConversation {
+			bot.EndConversation(ctx, scope)
+		}
+		c.Handler(bot, ctx, event, arg)
+		return
+	}
+	if r.Fallback != nil {
+		r.Fallback(bot, ctx, event, text)
+	}
+}
+
+// ボタンのデータを操作ごとの型で読み込む
+func (r *Router) decodePostbackData(action PostbackAction, data json.RawMessage) (PostbackData, error) {
+	var d PostbackData = new(Query)
+	if route, ok := r.postbacks[action]; ok && route.NewData != nil {
+		d = route.NewData()
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// HandlePostback routes the postback
+func (r *Router) HandlePostback(bot *Bot, ctx context.Context, event *linebot.Event, postback *Postback) {
+	route, ok := r.postbacks[postback.Action]
+	if !ok {
+		log.Print("unknown postback action: ", postback.Action)
+		return
+	}
+	scope := NewScope(event.Source)
+	if !route.Scope.allows(scope) {
+		bot.ReplyMessage(ctx, event, TextMessage(route.Scope.rejection("この操作")))
+		return
+	}
+	if !route.KeepsConversation {
+		bot.EndConversation(ctx, scope)
+	}
+	route.Handler(bot, ctx, event, postback.Data)
+}
+
+// Help lists the commands available in the scope
+func (r *Router) Help(scope Scope) string {
+	lines := []string{"使えるコマンド"}
+	for _, c := range r.commands {
+		if c.Help == "" || !c.Scope.allows(scope) {
+			continue
+		}
+		usage := c.Usage
+		if usage == "" {
+			usage = c.Text
+		}
+		if scope.IsGroup() && (c.Match != MatchExact || c.Mention) {
+			usage = GroupCommandPrefix + usage
+		}
+		lines = append(lines, "・"+usage+"\n　"+c.Help)
+	}
+	if r.FallbackHelp != "" {
+		lines = append(lines, "", r.FallbackHelp)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGroupTrigger(t *testing.T) {
+	bot := &Bot{Name: "ごはんbot"}
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{"/ラーメン", "ラーメン", true},
+		{"／ ラーメン", "ラーメン", true},
+		{"@ごはんbot ラーメン", "ラーメン", true},
+		{"/", "", true},
+		{"ラーメン", "", false},
+		{"@ほかのbot ラーメン", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := bot.GroupTrigger(tt.text)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("GroupTrigger(%q) = %q, %v, want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHandleTextInGroup(t *testing.T) {
+	ctx := context.Background()
+	group := Scope{Type: ScopeTypeGroup, ID: "G1"}
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		// 呼びかけだけのときは使い方
+		{"empty trigger", "/", []string{router.Help(group)}},
+		{"empty mention", "@testbot", []string{router.Help(group)}},
+		// 1対1だけのコマンドはルーターが断る
+		{"user command", "インポート", []string{CommandScopeUser.rejection("インポート")}},
+		{"user prefix command", "/" + JoinSharedListPrefix + " ABC123", []string{CommandScopeUser.rejection(JoinSharedListPrefix)}},
+	}
+	for _, tt := range tests {
+		bot, _, line := newTestBot(t)
+		router.HandleText(bot, ctx, groupEvent("G1", "U1", tt.name), tt.text)
+		if got := line.ReplyTexts(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: reply = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 呼びかけのない会話には反応しない
+	bot, _, line := newTestBot(t)
+	router.HandleText(bot, ctx, groupEvent("G1", "U1", "chat"), "ラーメン食べたい")
+	if got := line.Reply("chat"); len(got) != 0 {
+		t.Errorf("reply to chat = %s, want none", got)
+	}
+}
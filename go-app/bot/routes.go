@@ -0,0 +1,207 @@
+package bot
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/nlquery"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// ヘルプを表示するコマンド
+const HelpCommand = "ヘルプ"
+
+var router = NewRouter()
+
+// 処理がrouterを参照するので初期化の循環を避けてここで登録する
+func init() {
+	commands := []TextCommand{
+		{Text: "位置情報検索", Help: "位置情報を送って近くのお店を探す", Handler: noArg((*Bot).ShowLocationSendButton)},
+		{Text: "前回の条件で検索", Help: "前回の検索条件を表示する", Handler: noArg((*Bot).ShowLastSearch)},
+		{Text: "検索履歴", Help: "最近の検索条件から検索し直す", Handler: noArg((*Bot).ShowSearchHistory)},
+		{Text: "登録地点", Help: "登録した地点から検索する", Handler: noArg((*Bot).ShowSavedLocations)},
+		{Text: "登録地点を削除", Help: "登録した地点を削除する", Handler: noArg((*Bot).ShowDeleteSavedLocations)},
+		{Text: "お気に入りを見る", Help: "お気に入りのお店を表示する(グループでは共有リスト)", Handler: noArg(func(bot *Bot, ctx context.Context, event *linebot.Event) {
+			bot.ShowFavorite(ctx, event, &FavoriteListInfo{})
+		})},
+		{Text: "お気に入りルーレット", Help: "お気に入りからお店を1件選ぶ", Handler: noArg(func(bot *Bot, ctx context.Context, event *linebot.Event) {
+			bot.Roulette(ctx, event, &RouletteInfo{})
+		})},
+		{Text: "共有リスト", Help: "参加している共有リストを表示する", Handler: noArg((*Bot).ShowSharedLists)},
+		{Text: "招待コード", Help: "グループの共有リストの招待コードを発行する", Scope: CommandScopeGroup, Handler: noArg((*Bot).IssueInviteCode)},
+		{Text: "投票状況", Help: "投票の途中経過を表示する", Scope: CommandScopeGroup, Handler: noArg((*Bot).ShowPoll)},
+		{Text: "投票を締め切る", Help: "投票を締め切って結果を表示する", Scope: CommandScopeGroup, Handler: noArg((*Bot).ClosePoll)},
+		{Text: "履歴", Help: "行ったお店の記録を表示する", Handler: noArg((*Bot).ShowVisits)},
+		{Text: "エクスポート", Help: "お気に入りをファイルに書き出す", Handler: noArg((*Bot).ShowExportLinks)},
+		{Text: "インポート", Help: "ファイルからお気に入りを取り込む", Scope: CommandScopeUser, Handler: noArg((*Bot).ShowImportLink)},
+		{Text: BackCommand, Help: "入力をやめて検索の確認画面に戻る", Mention: true, KeepsConversation: true, Handler: noArg((*Bot).BackConversation)},
+		{Text: CancelCommand, Help: "入力中の操作や検索条件を取り消す", Mention: true, KeepsConversation: true, Handler: noArg((*Bot).CancelConversation)},
+		{
+			Text:    HelpCommand,
+			Match:   MatchRegexp,
+			Pattern: regexp.MustCompile(`^(?i)(ヘルプ|help|使い方|\?|？)$`),
+			Help:    "このメッセージを表示する",
+			Handler: noArg((*Bot).ShowHelp),
+		},
+		{
+			Text:    CommentPrefix,
+			Match:   MatchPrefix,
+			Usage:   CommentPrefix + "感想",
+			Help:    "最後に行ったお店に感想を残す",
+			Handler: (*Bot).CommentVisit,
+		},
+		{
+			Text:    MemoPrefix,
+			Match:   MatchPrefix,
+			Usage:   MemoPrefix + "メモ",
+			Help:    "編集中のお気に入りにメモを書く",
+			Handler: editFavoriteByText(MemoPrefix),
+		},
+		{
+			Text:    TagPrefix,
+			Match:   MatchPrefix,
+			Usage:   TagPrefix + "タグ",
+			Help:    "編集中のお気に入りにタグをつける",
+			Handler: editFavoriteByText(TagPrefix),
+		},
+		{
+			Text:    JoinSharedListPrefix,
+			Match:   MatchPrefix,
+			Usage:   JoinSharedListPrefix + " 招待コード",
+			Help:    "招待コードで共有リストに参加する",
+			Scope:   CommandScopeUser,
+			Handler: (*Bot).JoinSharedList,
+		},
+		{
+			Text:    LocationNamePrefix,
+			Match:   MatchPrefix,
+			Usage:   LocationNamePrefix + "名前",
+			Help:    "「この場所を登録」を選んだ地点に名前をつける",
+			Handler: (*Bot).RegisterLocationName,
+		},
+	}
+	for _, c := range commands {
+		router.Text(c)
+	}
+	router.Fallback = (*Bot).HandleFreeText
+	router.FallbackHelp = "ほかの文は検索条件として読み取ります\n例: 渋谷駅周辺で1km以内の安いラーメン"
+
+	placeInfo := func() PostbackData { return new(PlaceInfo) }
+	favoriteEditInfo := func() PostbackData { return new(FavoriteEditInfo) }
+	postbacks := []PostbackRoute{
+		// 検索条件
+		{Action: PostbackActionChangeRadius, KeepsConversation: true, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ChangeRadius(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionUpdateRadius, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.UpdateRadius(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionChangeKeyword, KeepsConversation: true, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ChangeKeyword(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionRemoveKeyword, NewData: func() PostbackData { return new(KeywordInfo) }, KeepsConversation: true, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.RemoveKeyword(ctx, event, data.(*KeywordInfo))
+		}},
+		{Action: PostbackActionChangeTravel, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ChangeTravel(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionUpdateTravel, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.UpdateTravel(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionNearbySearch, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ShowNearbyPlaces(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionApplyLastSearch, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ApplyLastSearch(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionReplaySearch, NewData: func() PostbackData { return new(SearchHistoryInfo) }, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ReplaySearch(ctx, event, data.(*SearchHistoryInfo))
+		}},
+		// 登録地点
+		{Action: PostbackActionRegisterLocation, KeepsConversation: true, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.RegisterLocation(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionUseSavedLocation, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.UseSavedLocation(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionDeleteSavedLocation, NewData: func() PostbackData { return new(LocationInfo) }, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.DeleteSavedLocation(ctx, event, data.(*LocationInfo))
+		}},
+		// お気に入り
+		{Action: PostbackActionAddFavorite, NewData: placeInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.AddFavorite(ctx, event, data.(*PlaceInfo))
+		}},
+		{Action: PostbackActionDeleteFavorite, NewData: placeInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.DeleteFavorite(ctx, event, data.(*PlaceInfo))
+		}},
+		{Action: PostbackActionShowFavorite, NewData: func() PostbackData { return new(FavoriteListInfo) }, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ShowFavorite(ctx, event, data.(*FavoriteListInfo))
+		}},
+		{Action: PostbackActionEditFavorite, NewData: placeInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.EditFavorite(ctx, event, data.(*PlaceInfo))
+		}},
+		{Action: PostbackActionToggleFavoriteTag, NewData: favoriteEditInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ToggleFavoriteTag(ctx, event, data.(*FavoriteEditInfo))
+		}},
+		{Action: PostbackActionChooseFavoriteRating, NewData: favoriteEditInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.ChooseFavoriteRating(ctx, event, data.(*FavoriteEditInfo))
+		}},
+		{Action: PostbackActionRateFavorite, NewData: favoriteEditInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.RateFavorite(ctx, event, data.(*FavoriteEditInfo))
+		}},
+		{Action: PostbackActionRoulette, NewData: func() PostbackData { return new(RouletteInfo) }, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.Roulette(ctx, event, data.(*RouletteInfo))
+		}},
+		// 訪問記録
+		{Action: PostbackActionVisit, NewData: placeInfo, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.RecordVisit(ctx, event, data.(*PlaceInfo))
+		}},
+		{Action: PostbackActionRateVisit, NewData: func() PostbackData { return new(VisitInfo) }, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.RateVisit(ctx, event, data.(*VisitInfo))
+		}},
+		// 投票
+		{Action: PostbackActionStartPoll, Scope: CommandScopeGroup, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.StartPoll(ctx, event, data.(*Query))
+		}},
+		{Action: PostbackActionVote, NewData: placeInfo, Scope: CommandScopeGroup, Handler: func(bot *Bot, ctx context.Context, event *linebot.Event, data PostbackData) {
+			bot.VotePlace(ctx, event, data.(*PlaceInfo))
+		}},
+	}
+	for _, p := range postbacks {
+		router.Postback(p)
+	}
+}
+
+func editFavoriteByText(prefix string) TextHandler {
+	return func(bot *Bot, ctx context.Context, event *linebot.Event, text string) {
+		bot.EditFavoriteByText(ctx, event, prefix, text)
+	}
+}
+
+// 使えるコマンドの一覧
+func (bot *Bot) ShowHelp(ctx context.Context, event *linebot.Event) {
+	bot.ReplyMessage(ctx, event, TextMessage(router.Help(NewScope(event.Source))))
+}
+
+// コマンドでない文．入力待ちならその入力，そうでなければ検索条件や「〜周辺」・住所の地名として扱う
+func (bot *Bot) HandleFreeText(ctx context.Context, event *linebot.Event, text string) {
+	if bot.HandleAwaitedInput(ctx, event, text) {
+		return
+	}
+	if r := nlquery.Parse(text); isTextSearch(&r) {
+		bot.SearchByText(ctx, event, &r)
+		return
+	}
+	if name, ok := LocationName(text); ok {
+		if !bot.SearchByLocationName(ctx, event, name) {
+			bot.ReplyMessage(ctx, event, TextMessage("場所が見つかりませんでした(´・ω・`)"))
+		}
+		return
+	}
+	// 何も求めていないときの文は地名とみなさずに使い方を案内する
+	prefix := ""
+	if NewScope(event.Source).IsGroup() {
+		prefix = GroupCommandPrefix
+	}
+	bot.ReplyMessage(ctx, event, TextMessage("場所から探すときは「"+prefix+"東京駅周辺」のように送信してください\nキーワードを追加するには，検索確認画面で「キーワードで絞り込み」を選んでから送信してください\n「"+prefix+HelpCommand+"」で使えるコマンドを表示します"))
+}
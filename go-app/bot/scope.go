@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 会話の種類
+type ScopeType string
+
+const (
+	ScopeTypeUser  ScopeType = "user"
+	ScopeTypeGroup ScopeType = "group"
+	ScopeTypeRoom  ScopeType = "room"
+)
+
+// 会話のスコープ
+// 1対1のトークではユーザごと，グループやトークルームではその会話ごとに状態を持つ
+type Scope struct {
+	Type ScopeType
+	ID   string
+	// 発言したユーザ
+	UserID string
+}
+
+func NewScope(src *linebot.EventSource) Scope {
+	switch src.Type {
+	case linebot.EventSourceTypeGroup:
+		return Scope{Type: ScopeTypeGroup, ID: src.GroupID, UserID: src.UserID}
+	case linebot.EventSourceTypeRoom:
+		return Scope{Type: ScopeTypeRoom, ID: src.RoomID, UserID: src.UserID}
+	}
+	return Scope{Type: ScopeTypeUser, ID: src.UserID, UserID: src.UserID}
+}
+
+// Datastoreのキーに使う名前
+// 1対1のトークではこれまで通りユーザIDそのものを使う
+func (s Scope) Key() string {
+	if s.Type == ScopeTypeUser {
+		return s.ID
+	}
+	return string(s.Type) + ":" + s.ID
+}
+
+// 複数人の会話か
+func (s Scope) IsGroup() bool {
+	return s.Type != ScopeTypeUser
+}
+
+// 個人のデータ(お気に入り・登録地点・訪問記録・好み)のキー．
+// これらはグループで使っても発言した人のものなので，会話ごとではなくユーザIDで持つ．
+// ボットを友だち追加していない人はグループでユーザIDが取れないので使えない
+func (s Scope) PersonalKey() (string, bool) {
+	return s.UserID, s.UserID != ""
+}
+
+// ユーザIDが取れなかったときの案内
+const noUserIDText = "この機能を使うにはボットを友だち追加してください"
+
+// 個人のデータのキー．取れなければその旨を返信する
+func (bot *Bot) personalKey(ctx context.Context, event *linebot.Event) (string, bool) {
+	userID, ok := NewScope(event.Source).PersonalKey()
+	if !ok {
+		bot.ReplyMessage(ctx, event, TextMessage(noUserIDText))
+	}
+	return userID, ok
+}
+
+// グループで話しかけるときの接頭辞
+const GroupCommandPrefix = "/"
+
+// グループでのメッセージからボット宛ての部分を取り出す
+// 「/ラーメン」や「@ボット名 ラーメン」のように呼びかけられたときだけ反応する
+func (bot *Bot) GroupTrigger(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	for _, prefix := range []string{GroupCommandPrefix, "／"} {
+		if strings.HasPrefix(text, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(text, prefix)), true
+		}
+	}
+	if bot.Name != "" {
+		mention := "@" + bot.Name
+		if strings.HasPrefix(text, mention) {
+			return strings.TrimSpace(strings.TrimPrefix(text, mention)), true
+		}
+	}
+	return "", false
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
 )
@@ -17,9 +20,33 @@ const (
 	SearchTypeDetails SearchType = "details"
 )
 
-// NearbySearch
+// キーワードの組み合わせごとに周辺のお店を検索してまとめる．
+// 除外キーワードに当てはまるお店は結果から除く
 func (bot *Bot) NearbySearch(query *Query) (*places.Places, error) {
-	uri := buildURI(SearchTypeNearby, bot.nearbySearchParams(query))
+	ks := ParseKeywords(query.Keywords)
+	searches, _ := ks.Searches()
+	results := make([]places.Places, len(searches))
+	errs := make([]error, len(searches))
+	var wg sync.WaitGroup
+	for i := range searches {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = bot.nearbySearch(query, searches[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	p := ks.Filter(mergePlaces(results))
+	return &p, nil
+}
+
+func (bot *Bot) nearbySearch(query *Query, keywords []string) (places.Places, error) {
+	uri := buildURI(SearchTypeNearby, bot.nearbySearchParams(query, keywords))
 	fmt.Println("[URI]", uri)
 	resp, err := http.Get(uri)
 	if err != nil {
@@ -34,12 +61,34 @@ func (bot *Bot) NearbySearch(query *Query) (*places.Places, error) {
 	var nearby places.NearbyPlaces
 	json.Unmarshal(body, &nearby)
 
-	p := nearby.MarshalPlaces(bot.GCPPlacesAPIKey)
-	return &p, nil
+	return nearby.MarshalPlaces(bot.GCPPlacesAPIKey), nil
 }
 
 // DetailsSearch
 func (bot *Bot) DetailsSearch(placeID string) (*places.Place, error) {
+	details, err := bot.details(placeID)
+	if err != nil {
+		return nil, err
+	}
+	p := details.MarshalPlace()
+	return &p, nil
+}
+
+// DetailsSearchWithPhoto also resolves photo uri
+func (bot *Bot) DetailsSearchWithPhoto(placeID string) (*places.Place, error) {
+	details, err := bot.details(placeID)
+	if err != nil {
+		return nil, err
+	}
+	p := details.MarshalPlace()
+	p.PhotoURI = details.PhotoURI(map[string]string{
+		"key":      bot.GCPPlacesAPIKey,
+		"maxwidth": "350",
+	})
+	return &p, nil
+}
+
+func (bot *Bot) details(placeID string) (*places.Details, error) {
 	uri := buildURI(SearchTypeDetails, bot.detailsSearchMap(placeID))
 	fmt.Println("[URI]", uri)
 	resp, err := http.Get(uri)
@@ -53,22 +102,58 @@ func (bot *Bot) DetailsSearch(placeID string) (*places.Place, error) {
 		return nil, err
 	}
 	var details places.PlaceDetails
-	json.Unmarshal(body, &details)
+	if err := json.Unmarshal(body, &details); err != nil {
+		return nil, err
+	}
+	return details.Found()
+}
 
-	p := details.Result.MarshalPlace()
-	return &p, nil
+// AddTravelTimes sets travel time from the query location to each place
+func (bot *Bot) AddTravelTimes(query *Query, p places.Places) error {
+	if query.TravelMode == "" || len(p) == 0 {
+		return nil
+	}
+	n := len(p)
+	if n > places.MaxDestinations {
+		n = places.MaxDestinations
+	}
+	destinations := make([]places.LatLng, n)
+	for i := 0; i < n; i++ {
+		destinations[i] = p[i].LatLng()
+	}
+	origin := places.LatLng{Lat: query.Lat, Lng: query.Lng}
+	times, err := bot.DistanceMatrix.TravelTimes(origin, destinations, query.TravelMode)
+	if err != nil {
+		return err
+	}
+	for i := range times {
+		p[i].TravelTime = times[i]
+	}
+	if query.SortByTravelTime {
+		p.SortByTravelTime()
+	}
+	return nil
 }
 
 // make nearby search params
-func (bot *Bot) nearbySearchParams(query *Query) map[string]string {
+func (bot *Bot) nearbySearchParams(query *Query, keywords []string) map[string]string {
 	params := map[string]string{
 		"key":      bot.GCPPlacesAPIKey,
 		"type":     "restaurant",
 		"location": query.Lat + "," + query.Lng,
 		"radius":   query.Radius,
 	}
-	if len(query.Keywords) > 0 {
-		params["keyword"] = strings.Join(query.Keywords, "+")
+	if len(keywords) > 0 {
+		params["keyword"] = url.QueryEscape(strings.Join(keywords, " "))
+	}
+	if query.Type != "" {
+		params["type"] = query.Type
+	}
+	if query.MaxPrice > 0 {
+		params["maxprice"] = strconv.Itoa(query.MaxPrice)
+	}
+	if query.OpenNow {
+		params["opennow"] = "true"
 	}
 	return params
 }
@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+// 目的地の緯度を分数とみなして移動時間を返す
+type fakeDistanceMatrix struct {
+	calls [][]places.LatLng
+	err   error
+}
+
+func (f *fakeDistanceMatrix) TravelTimes(origin places.LatLng, destinations []places.LatLng, mode places.TravelMode) ([]places.TravelTime, error) {
+	f.calls = append(f.calls, destinations)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(destinations) > places.MaxDestinations {
+		return nil, fmt.Errorf("too many destinations: %d", len(destinations))
+	}
+	times := make([]places.TravelTime, len(destinations))
+	for i, d := range destinations {
+		minutes, err := strconv.Atoi(d.Lat)
+		if err != nil {
+			return nil, err
+		}
+		times[i] = places.TravelTime{Mode: mode, Duration: time.Duration(minutes) * time.Minute, Text: d.Lat + "分"}
+	}
+	return times, nil
+}
+
+// 移動時間が minutes[i] 分のお店
+func travelPlaces(minutes []int) places.Places {
+	p := make(places.Places, len(minutes))
+	for i, m := range minutes {
+		p[i] = places.Place{PlaceID: fmt.Sprintf("p%02d", i), Lat: strconv.Itoa(m), Lng: "139"}
+	}
+	return p
+}
+
+func placeIDs(p places.Places) []string {
+	ids := make([]string, len(p))
+	for i := range p {
+		ids[i] = p[i].PlaceID
+	}
+	return ids
+}
+
+func TestAddTravelTimes(t *testing.T) {
+	// 上限を超える分は移動時間を取らない
+	minutes := make([]int, places.MaxDestinations+3)
+	for i := range minutes {
+		minutes[i] = len(minutes) - i
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		fake := &fakeDistanceMatrix{}
+		bot := &Bot{DistanceMatrix: fake}
+		p := travelPlaces(minutes)
+		q := Query{Lat: "35", Lng: "139", TravelMode: places.TravelModeWalking}
+		if err := bot.AddTravelTimes(&q, p); err != nil {
+			t.Fatal(err)
+		}
+		if len(fake.calls) != 1 || len(fake.calls[0]) != places.MaxDestinations {
+			t.Fatalf("calls = %v, want 1 call with %d destinations", fake.calls, places.MaxDestinations)
+		}
+		for i := range p {
+			if want := i < places.MaxDestinations; p[i].TravelTime.Valid() != want {
+				t.Errorf("travel time of %s = %+v, want valid: %v", p[i].PlaceID, p[i].TravelTime, want)
+			}
+		}
+		// 並べ替えない
+		if got := placeIDs(p); !reflect.DeepEqual(got, placeIDs(travelPlaces(minutes))) {
+			t.Errorf("order = %v, want unchanged", got)
+		}
+	})
+
+	t.Run("sort", func(t *testing.T) {
+		fake := &fakeDistanceMatrix{}
+		bot := &Bot{DistanceMatrix: fake}
+		p := travelPlaces(minutes)
+		q := Query{Lat: "35", Lng: "139", TravelMode: places.TravelModeTransit, SortByTravelTime: true}
+		if err := bot.AddTravelTimes(&q, p); err != nil {
+			t.Fatal(err)
+		}
+		// 移動時間の短い順で，移動時間のないお店は元の順番のまま後ろに
+		want := []string{}
+		for i := places.MaxDestinations - 1; i >= 0; i-- {
+			want = append(want, fmt.Sprintf("p%02d", i))
+		}
+		for i := places.MaxDestinations; i < len(minutes); i++ {
+			want = append(want, fmt.Sprintf("p%02d", i))
+		}
+		if got := placeIDs(p); !reflect.DeepEqual(got, want) {
+			t.Errorf("order = %v, want %v", got, want)
+		}
+		if p[0].TravelTime.Mode != places.TravelModeTransit {
+			t.Errorf("mode = %q, want %q", p[0].TravelTime.Mode, places.TravelModeTransit)
+		}
+	})
+
+	t.Run("no mode", func(t *testing.T) {
+		fake := &fakeDistanceMatrix{}
+		bot := &Bot{DistanceMatrix: fake}
+		p := travelPlaces([]int{3, 1})
+		if err := bot.AddTravelTimes(&Query{SortByTravelTime: true}, p); err != nil {
+			t.Fatal(err)
+		}
+		if len(fake.calls) != 0 || p[0].TravelTime.Valid() {
+			t.Errorf("travel times are requested without travel mode")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		fake := &fakeDistanceMatrix{err: errors.New("quota exceeded")}
+		bot := &Bot{DistanceMatrix: fake}
+		p := travelPlaces([]int{3, 1})
+		q := Query{TravelMode: places.TravelModeWalking, SortByTravelTime: true}
+		if err := bot.AddTravelTimes(&q, p); err != fake.err {
+			t.Errorf("AddTravelTimes() = %v, want %v", err, fake.err)
+		}
+		if got := placeIDs(p); !reflect.DeepEqual(got, []string{"p00", "p01"}) {
+			t.Errorf("order = %v, want unchanged", got)
+		}
+	})
+}
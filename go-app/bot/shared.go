@@ -0,0 +1,521 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 共有リストに参加するときの接頭辞
+const JoinSharedListPrefix = "共有リストに参加"
+
+// グループが持つ共有のお気に入り
+// キーはグループのスコープ(Scope.Key)
+type SharedFavorite struct {
+	Name       string         `datastore:"name,noindex"`
+	InviteCode string         `datastore:"invite_code,noindex"`
+	List       []FavoriteItem `datastore:"list,noindex"`
+	mystore.Timestamp
+}
+
+func (shared *SharedFavorite) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("SharedFavorite", name, parent)
+}
+
+const (
+	// 招待コードの有効期間と，発行するときにコードの重複で作り直す回数
+	InviteCodeTTL         = 7 * 24 * time.Hour
+	maxInviteCodeAttempts = 5
+	// 招待コードを間違えられる回数と，数える期間
+	MaxInviteFailures   = 5
+	InviteFailureWindow = time.Hour
+)
+
+// 招待コードから共有リストを引く
+// キーは招待コード
+type InviteCode struct {
+	ListID    string    `datastore:"list_id,noindex"`
+	ExpiresAt time.Time `datastore:"expires_at"`
+	mystore.Timestamp
+}
+
+func (code *InviteCode) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("InviteCode", name, parent)
+}
+
+// 招待コードの期限を過ぎたか．期限を記録する前のコードも期限切れとして扱う
+func (code *InviteCode) Expired(now time.Time) bool {
+	return now.After(code.ExpiresAt)
+}
+
+// ユーザが招待コードを間違えた回数．総当たりで参加されないように数える
+type InviteFailure struct {
+	Count     int       `datastore:"count,noindex"`
+	ExpiresAt time.Time `datastore:"expires_at"`
+	mystore.Timestamp
+}
+
+func (f *InviteFailure) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("InviteFailure", name, parent)
+}
+
+// ユーザが招待コードで参加した共有リスト
+type SharedMembership struct {
+	ListIDs []string `datastore:"list_ids,noindex"`
+	mystore.Timestamp
+}
+
+func (m *SharedMembership) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("SharedMembership", name, parent)
+}
+
+func (m *SharedMembership) Has(listID string) bool {
+	for _, id := range m.ListIDs {
+		if id == listID {
+			return true
+		}
+	}
+	return false
+}
+
+// 共有リストへの追加を断る理由
+var (
+	errFavoriteExists = errors.New("bot: place is already in the list")
+	errFavoriteFull   = errors.New("bot: list is full")
+)
+
+var errInviteCodeTaken = errors.New("bot: invite code is already used")
+
+// 招待コードの文字(紛らわしい文字を除く)
+const inviteCodeLetters = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func newInviteCode() (string, error) {
+	code := make([]byte, 6)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteCodeLetters))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteCodeLetters[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// 発言したユーザの表示名
+func (bot *Bot) displayName(scope Scope) string {
+	var profile *linebot.UserProfileResponse
+	var err error
+	switch scope.Type {
+	case ScopeTypeGroup:
+		profile, err = bot.LINEBotClient.GetGroupMemberProfile(scope.ID, scope.UserID).Do()
+	case ScopeTypeRoom:
+		profile, err = bot.LINEBotClient.GetRoomMemberProfile(scope.ID, scope.UserID).Do()
+	default:
+		profile, err = bot.LINEBotClient.GetProfile(scope.UserID).Do()
+	}
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	return profile.DisplayName
+}
+
+// 共有リストの名前
+func (bot *Bot) sharedListName(scope Scope) string {
+	if scope.Type == ScopeTypeGroup {
+		if summary, err := bot.LINEBotClient.GetGroupSummary(scope.ID).Do(); err == nil {
+			return summary.GroupName
+		}
+	}
+	return "トークルーム"
+}
+
+// 操作対象の共有リストのIDを決める
+// グループではそのグループのリスト，1対1では参加済みのリストのみ
+func (bot *Bot) sharedListID(ctx context.Context, scope Scope, listID string) (string, bool) {
+	if scope.IsGroup() {
+		return scope.Key(), true
+	}
+	m := SharedMembership{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &m, scope.UserID, nil); err != nil {
+		return "", false
+	}
+	return listID, m.Has(listID)
+}
+
+// 共有リストに追加
+func (bot *Bot) AddSharedFavorite(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
+	scope := NewScope(event.Source)
+	listID, ok := bot.sharedListID(ctx, scope, info.ListID)
+	if !ok {
+		bot.ReplyMessage(ctx, event, TextMessage("この共有リストには参加していません"))
+		return
+	}
+	p, err := bot.DetailsSearch(info.PlaceID)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入り登録に失敗しました..."))
+		return
+	}
+
+	p.PhotoURI = info.PhotoURI
+	item := FavoriteItem{
+		Place:   *p,
+		AddedBy: bot.displayName(scope),
+	}
+	// 同時に追加されても消さないようにトランザクションで追加し，容量もその中で確かめる
+	var shared SharedFavorite
+	key := (&SharedFavorite{}).NameKey(listID, nil)
+	_, err = bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		shared = SharedFavorite{}
+		err := tx.Get(key, &shared)
+		if err == datastore.ErrNoSuchEntity && scope.IsGroup() {
+			// グループのリストがなければ作成
+			shared.Name = bot.sharedListName(scope)
+			shared.List = []FavoriteItem{}
+		} else if err != nil {
+			return err
+		}
+		if FindFavoriteItem(shared.List, item.PlaceID) != nil {
+			return errFavoriteExists
+		}
+		shared.List = append(shared.List, item)
+		size, err := mystore.Size(&shared)
+		if err != nil {
+			return err
+		}
+		if size > MaxFavoriteBytes {
+			return errFavoriteFull
+		}
+		shared.Touch(time.Now())
+		_, err = tx.Put(key, &shared)
+		return err
+	})
+	switch err {
+	case nil:
+	case errFavoriteExists:
+		bot.ReplyMessage(ctx, event, TextMessage("このお店は登録済みです"))
+		return
+	case errFavoriteFull:
+		bot.ReplyMessage(ctx, event, TextMessage("共有リストがいっぱいです\nいくつか削除してから登録してください"))
+		return
+	default:
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入り登録に失敗しました..."))
+		return
+	}
+
+	text := fmt.Sprintf("「%s」の共有リストに登録しました! (%d件)", shared.Name, len(shared.List))
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+// 共有リストから削除
+func (bot *Bot) DeleteSharedFavorite(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
+	scope := NewScope(event.Source)
+	listID, ok := bot.sharedListID(ctx, scope, info.ListID)
+	if !ok {
+		bot.ReplyMessage(ctx, event, TextMessage("この共有リストには参加していません"))
+		return
+	}
+	// 同時に書き換えられても消さないようにトランザクションで削除する
+	key := (&SharedFavorite{}).NameKey(listID, nil)
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		shared := SharedFavorite{}
+		if err := tx.Get(key, &shared); err != nil {
+			return err
+		}
+		newList := []FavoriteItem{}
+		for _, item := range shared.List {
+			if info.PlaceID != item.PlaceID {
+				newList = append(newList, item)
+			}
+		}
+		if len(newList) == len(shared.List) {
+			return ErrFavoriteNotFound
+		}
+		shared.List = newList
+		shared.Touch(time.Now())
+		_, err := tx.Put(key, &shared)
+		return err
+	})
+	if err == ErrFavoriteNotFound {
+		bot.ReplyMessage(ctx, event, TextMessage("すでに削除されています"))
+		return
+	} else if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("お気に入り削除に失敗しました..."))
+		return
+	}
+	bot.ReplyMessage(ctx, event, TextMessage("共有リストから削除しました!"))
+}
+
+// 共有リストを表示
+func (bot *Bot) ShowSharedFavorite(ctx context.Context, event *linebot.Event, info *FavoriteListInfo) {
+	scope := NewScope(event.Source)
+	listID, ok := bot.sharedListID(ctx, scope, info.ListID)
+	if !ok {
+		bot.ReplyMessage(ctx, event, TextMessage("この共有リストには参加していません"))
+		return
+	}
+	shared := SharedFavorite{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &shared, listID, nil)
+	if err == datastore.ErrNoSuchEntity || len(shared.List) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("共有リストにお店がありません"))
+		return
+	}
+	// 1対1で見るときはページ送りにリストIDが必要
+	pageInfo := FavoriteListInfo{Page: info.Page, Tag: info.Tag}
+	if !scope.IsGroup() {
+		pageInfo.ListID = listID
+	}
+	list := FilterFavoriteItems(shared.List, info.Tag)
+	if len(list) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("#"+info.Tag+" のお店がありません"))
+		return
+	}
+	start, end, nav := Paginate(len(list), MaxPlaces-1, &pageInfo)
+	sharedPlaces := SharedFavoritePlaces{ListID: pageInfo.ListID, List: list[start:end]}
+	carousel := PagedCarousel{Items: &sharedPlaces, Nav: nav}
+	bot.ReplyMessage(ctx, event, CarouselMessage(&carousel, MaxPlaces).WithQuickReplies(FavoriteListQuickReplyItems(shared.List, &pageInfo)))
+}
+
+// 参加している共有リストを選ぶ
+func (bot *Bot) ShowSharedLists(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	if scope.IsGroup() {
+		bot.ShowSharedFavorite(ctx, event, &FavoriteListInfo{})
+		return
+	}
+	m := SharedMembership{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &m, scope.UserID, nil); err != nil || len(m.ListIDs) == 0 {
+		text := fmt.Sprintf("参加している共有リストがありません\nグループで「招待コード」と送信してコードを発行し，ここで「%s コード」と送信してください", JoinSharedListPrefix)
+		bot.ReplyMessage(ctx, event, TextMessage(text))
+		return
+	}
+	lists := bot.sharedLists(ctx, m.ListIDs)
+	bot.ReplyMessage(ctx, event, SharedListsQuickReply(lists))
+}
+
+// 共有リストのIDと名前
+type sharedList struct {
+	ID   string
+	Name string
+}
+
+func (bot *Bot) sharedLists(ctx context.Context, listIDs []string) []sharedList {
+	lists := make([]sharedList, 0, len(listIDs))
+	for _, id := range listIDs {
+		shared := SharedFavorite{}
+		if err := mystore.Get(ctx, bot.DatastoreClient, &shared, id, nil); err != nil {
+			continue
+		}
+		lists = append(lists, sharedList{ID: id, Name: shared.Name})
+	}
+	return lists
+}
+
+// 1対1でお気に入りに追加したあと共有リストにも追加できるようにする
+func (bot *Bot) sharedListQuickReplyItems(ctx context.Context, userID string, info *PlaceInfo) *linebot.QuickReplyItems {
+	m := SharedMembership{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &m, userID, nil); err != nil || len(m.ListIDs) == 0 {
+		return nil
+	}
+	buttons := make([]*linebot.QuickReplyButton, 0)
+	for _, list := range bot.sharedLists(ctx, m.ListIDs) {
+		listInfo := *info
+		listInfo.ListID = list.ID
+		label := truncate(list.Name, 14) + "にも追加"
+		postbackString := PostbackJSON(PostbackActionAddFavorite, &listInfo)
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction(label, postbackString, "", "")))
+	}
+	if len(buttons) == 0 {
+		return nil
+	}
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
+// 使われていない招待コードを作って保存する．
+// 同じコードがあれば上書きせずに作り直す(期限切れのコードは使い回す)
+func (bot *Bot) createInviteCode(ctx context.Context, listID string, generate func() (string, error)) (string, error) {
+	for i := 0; i < maxInviteCodeAttempts; i++ {
+		code, err := generate()
+		if err != nil {
+			return "", err
+		}
+		now := time.Now()
+		invite := InviteCode{ListID: listID, ExpiresAt: now.Add(InviteCodeTTL)}
+		invite.Touch(now)
+		key := invite.NameKey(code, nil)
+		_, err = bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			existing := InviteCode{}
+			err := tx.Get(key, &existing)
+			if err == nil && !existing.Expired(now) {
+				return errInviteCodeTaken
+			} else if err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			_, err = tx.Put(key, &invite)
+			return err
+		})
+		if err == errInviteCodeTaken {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return code, nil
+	}
+	return "", errInviteCodeTaken
+}
+
+// 有効な招待コード．なければ空
+func (bot *Bot) validInviteCode(ctx context.Context, listID, code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	invite := InviteCode{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &invite, code, nil)
+	if err == datastore.ErrNoSuchEntity {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if invite.Expired(time.Now()) || invite.ListID != listID {
+		return "", nil
+	}
+	return code, nil
+}
+
+// グループの共有リストの招待コードを発行する
+func (bot *Bot) IssueInviteCode(ctx context.Context, event *linebot.Event) {
+	scope := NewScope(event.Source)
+	listID := scope.Key()
+	shared := SharedFavorite{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &shared, listID, nil)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("招待コードの発行に失敗しました..."))
+		return
+	}
+	code, err := bot.validInviteCode(ctx, listID, shared.InviteCode)
+	if err == nil && code == "" {
+		code, err = bot.createInviteCode(ctx, listID, newInviteCode)
+	}
+	if err == nil && code != shared.InviteCode {
+		err = bot.saveInviteCode(ctx, scope, code)
+	}
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("招待コードの発行に失敗しました..."))
+		return
+	}
+	text := fmt.Sprintf("共有リストの招待コードは %s です(%d日間有効)\nボットとの1対1のトークで「%s %s」と送信すると参加できます", code, int(InviteCodeTTL.Hours()/24), JoinSharedListPrefix, code)
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+// 共有リストに招待コードを記録する．同時に追加されたお店を消さないようにトランザクションで更新する
+func (bot *Bot) saveInviteCode(ctx context.Context, scope Scope, code string) error {
+	key := (&SharedFavorite{}).NameKey(scope.Key(), nil)
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		shared := SharedFavorite{}
+		err := tx.Get(key, &shared)
+		if err == datastore.ErrNoSuchEntity {
+			shared.Name = bot.sharedListName(scope)
+			shared.List = []FavoriteItem{}
+		} else if err != nil {
+			return err
+		}
+		shared.InviteCode = code
+		shared.Touch(time.Now())
+		_, err = tx.Put(key, &shared)
+		return err
+	})
+	return err
+}
+
+// 招待コードを間違えた回数を数える．期間が過ぎていれば数え直す
+func (bot *Bot) countInviteFailure(ctx context.Context, userID string) {
+	key := (&InviteFailure{}).NameKey(userID, nil)
+	_, err := bot.DatastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		now := time.Now()
+		f := InviteFailure{}
+		if err := tx.Get(key, &f); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if now.After(f.ExpiresAt) {
+			f = InviteFailure{ExpiresAt: now.Add(InviteFailureWindow)}
+		}
+		f.Count++
+		f.Touch(now)
+		_, err := tx.Put(key, &f)
+		return err
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// 招待コードを間違えすぎたか
+func (bot *Bot) tooManyInviteFailures(ctx context.Context, userID string) bool {
+	f := InviteFailure{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &f, userID, nil); err != nil {
+		return false
+	}
+	return !time.Now().After(f.ExpiresAt) && f.Count >= MaxInviteFailures
+}
+
+// 招待コードで共有リストに参加する
+func (bot *Bot) JoinSharedList(ctx context.Context, event *linebot.Event, code string) {
+	scope := NewScope(event.Source)
+	if bot.tooManyInviteFailures(ctx, scope.UserID) {
+		bot.ReplyMessage(ctx, event, TextMessage("招待コードを何度も間違えたため，しばらくしてからもう一度送信してください"))
+		return
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	invite := InviteCode{}
+	err := mystore.Get(ctx, bot.DatastoreClient, &invite, code, nil)
+	if err == datastore.ErrNoSuchEntity || (err == nil && invite.Expired(time.Now())) {
+		bot.countInviteFailure(ctx, scope.UserID)
+		bot.ReplyMessage(ctx, event, TextMessage("招待コードが見つからないか，有効期限が切れています\nグループでもう一度「招待コード」と送信してもらってください"))
+		return
+	} else if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("共有リストへの参加に失敗しました..."))
+		return
+	}
+	shared := SharedFavorite{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &shared, invite.ListID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("共有リストが見つかりませんでした"))
+		return
+	}
+	m := SharedMembership{}
+	err = mystore.Get(ctx, bot.DatastoreClient, &m, scope.UserID, nil)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		bot.ReplyMessage(ctx, event, TextMessage("共有リストへの参加に失敗しました..."))
+		return
+	}
+	if m.Has(invite.ListID) {
+		bot.ReplyMessage(ctx, event, TextMessage("この共有リストには参加済みです"))
+		return
+	}
+	m.ListIDs = append(m.ListIDs, invite.ListID)
+	if err := mystore.Save(ctx, bot.DatastoreClient, &m, scope.UserID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("共有リストへの参加に失敗しました..."))
+		return
+	}
+	text := fmt.Sprintf("「%s」の共有リストに参加しました!\n「共有リスト」と送信すると見られます", shared.Name)
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
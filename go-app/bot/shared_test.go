@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func TestSharedFavoriteConcurrentEdits(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	scope := Scope{Type: ScopeTypeGroup, ID: "G1"}
+	shared := SharedFavorite{Name: "グループ", List: []FavoriteItem{
+		{Place: places.Place{PlaceID: "p1"}},
+		{Place: places.Place{PlaceID: "p2"}},
+		{Place: places.Place{PlaceID: "p3"}},
+	}}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &shared, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 別々のメンバーが同時にタグをつけたり削除したりする
+	tags := []string{"ランチ", "ディナー", "デート"}
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		wg.Add(1)
+		go func(i int, tag string) {
+			defer wg.Done()
+			event := groupEvent("G1", fmt.Sprintf("U%d", i), "tag"+tag)
+			bot.ToggleFavoriteTag(ctx, event, &FavoriteEditInfo{PlaceID: "p1", Tag: tag})
+		}(i, tag)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		bot.DeleteSharedFavorite(ctx, groupEvent("G1", "U9", "delete"), &PlaceInfo{PlaceID: "p3"})
+	}()
+	wg.Wait()
+
+	saved := SharedFavorite{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &saved, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// 成功を返信した変更はすべて残っている
+	item := FindFavoriteItem(saved.List, "p1")
+	for _, tag := range tags {
+		failed := false
+		for _, text := range line.ReplyTexts("tag" + tag) {
+			failed = failed || text == "お気に入りの編集に失敗しました..."
+		}
+		if !failed && !item.HasTag(tag) {
+			t.Errorf("tag %q is lost: %v", tag, item.Tags)
+		}
+	}
+	deleted := line.ReplyTexts("delete")
+	if len(deleted) == 1 && deleted[0] == "共有リストから削除しました!" && FindFavoriteItem(saved.List, "p3") != nil {
+		t.Errorf("deleted place is restored: %+v", saved.List)
+	}
+	if FindFavoriteItem(saved.List, "p2") == nil {
+		t.Errorf("untouched place is lost: %+v", saved.List)
+	}
+}
+
+// 決まった順にコードを返す
+func inviteCodes(codes ...string) func() (string, error) {
+	return func() (string, error) {
+		code := codes[0]
+		codes = codes[1:]
+		return code, nil
+	}
+}
+
+func TestCreateInviteCode(t *testing.T) {
+	ctx := context.Background()
+	bot, _, _ := newTestBot(t)
+	now := time.Now()
+	used := InviteCode{ListID: "other", ExpiresAt: now.Add(time.Hour)}
+	expired := InviteCode{ListID: "old", ExpiresAt: now.Add(-time.Hour)}
+	for code, invite := range map[string]*InviteCode{"USED22": &used, "OLD222": &expired} {
+		if err := mystore.Save(ctx, bot.DatastoreClient, invite, code, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func(code string) InviteCode {
+		invite := InviteCode{}
+		if err := mystore.Get(ctx, bot.DatastoreClient, &invite, code, nil); err != nil {
+			t.Fatal(err)
+		}
+		return invite
+	}
+
+	// 使われているコードは上書きせずに作り直す
+	code, err := bot.createInviteCode(ctx, "G1", inviteCodes("USED22", "NEW222"))
+	if err != nil || code != "NEW222" {
+		t.Fatalf("createInviteCode() = %q, %v, want NEW222", code, err)
+	}
+	if got := load("USED22"); got.ListID != "other" {
+		t.Errorf("used code is overwritten: %+v", got)
+	}
+	if got := load("NEW222"); got.ListID != "G1" || got.Expired(now.Add(InviteCodeTTL-time.Minute)) {
+		t.Errorf("new code = %+v, want G1 valid for %v", got, InviteCodeTTL)
+	}
+
+	// 期限切れのコードは使い回す
+	code, err = bot.createInviteCode(ctx, "G2", inviteCodes("OLD222"))
+	if err != nil || code != "OLD222" || load("OLD222").ListID != "G2" {
+		t.Errorf("createInviteCode() = %q, %v, want OLD222 for G2", code, err)
+	}
+
+	// 作り直しても重なり続けたらあきらめる
+	codes := []string{}
+	for i := 0; i < maxInviteCodeAttempts; i++ {
+		codes = append(codes, "USED22")
+	}
+	if _, err := bot.createInviteCode(ctx, "G3", inviteCodes(codes...)); err != errInviteCodeTaken {
+		t.Errorf("createInviteCode() error = %v, want %v", err, errInviteCodeTaken)
+	}
+}
+
+func TestIssueInviteCodeKeepsList(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	scope := Scope{Type: ScopeTypeGroup, ID: "G1"}
+	shared := SharedFavorite{Name: "グループ", List: []FavoriteItem{{Place: places.Place{PlaceID: "p1"}}}}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &shared, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.IssueInviteCode(ctx, groupEvent("G1", "U1", "issue1"))
+	bot.IssueInviteCode(ctx, groupEvent("G1", "U1", "issue2"))
+	saved := SharedFavorite{}
+	if err := mystore.Get(ctx, bot.DatastoreClient, &saved, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if saved.InviteCode == "" || len(saved.List) != 1 {
+		t.Fatalf("shared list = %+v, want the code and the place", saved)
+	}
+	// 有効なうちは同じコードを返す
+	for _, token := range []string{"issue1", "issue2"} {
+		texts := line.ReplyTexts(token)
+		if len(texts) != 1 || !strings.Contains(texts[0], saved.InviteCode) {
+			t.Errorf("%s: reply = %q, want code %s", token, texts, saved.InviteCode)
+		}
+	}
+}
+
+func TestJoinSharedListFailures(t *testing.T) {
+	ctx := context.Background()
+	bot, _, line := newTestBot(t)
+	scope := Scope{Type: ScopeTypeGroup, ID: "G1"}
+	shared := SharedFavorite{Name: "グループ"}
+	if err := mystore.Save(ctx, bot.DatastoreClient, &shared, scope.Key(), nil); err != nil {
+		t.Fatal(err)
+	}
+	valid := InviteCode{ListID: scope.Key(), ExpiresAt: time.Now().Add(time.Hour)}
+	expired := InviteCode{ListID: scope.Key(), ExpiresAt: time.Now().Add(-time.Hour)}
+	for code, invite := range map[string]*InviteCode{"VALID2": &valid, "EXPIRE": &expired} {
+		if err := mystore.Save(ctx, bot.DatastoreClient, invite, code, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bot.JoinSharedList(ctx, userEvent("U1", "expired"), "expire")
+	if texts := line.ReplyTexts("expired"); len(texts) != 1 || !strings.Contains(texts[0], "有効期限") {
+		t.Errorf("reply = %q, want expired", texts)
+	}
+	// 間違えすぎると正しいコードでも受け付けない
+	for i := 1; i < MaxInviteFailures; i++ {
+		bot.JoinSharedList(ctx, userEvent("U1", "wrong"), fmt.Sprintf("WRONG%d", i))
+	}
+	bot.JoinSharedList(ctx, userEvent("U1", "locked"), "VALID2")
+	if texts := line.ReplyTexts("locked"); len(texts) != 1 || !strings.Contains(texts[0], "しばらくしてから") {
+		t.Errorf("reply = %q, want locked", texts)
+	}
+	// ほかのユーザは参加できる
+	bot.JoinSharedList(ctx, userEvent("U2", "join"), "valid2")
+	if texts := line.ReplyTexts("join"); len(texts) != 1 || !strings.Contains(texts[0], "参加しました") {
+		t.Errorf("reply = %q, want joined", texts)
+	}
+}
@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/nlquery"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/richmenu"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 文で検索条件が指定されたか
+func isTextSearch(r *nlquery.Result) bool {
+	return r.HasConditions() || (r.Location != "" && len(r.Keywords) > 0)
+}
+
+// 文から読み取った条件をクエリに反映する
+func applyTextConditions(q *Query, r *nlquery.Result) {
+	if r.Radius > 0 {
+		q.Radius = strconv.Itoa(r.Radius)
+	}
+	if r.MaxPrice > 0 {
+		q.MaxPrice = r.MaxPrice
+	}
+	if r.OpenNow {
+		q.OpenNow = true
+	}
+	if r.Type != "" {
+		q.Type = r.Type
+	}
+	for _, k := range r.Keywords {
+		if !containsString(q.Keywords, k) {
+			q.Keywords = append(q.Keywords, k)
+		}
+	}
+}
+
+// 「渋谷駅周辺で1km以内の安いラーメン」のような文から検索条件を読み取り，検索確認ウィンドウを返す．
+// 場所が書かれていなければ直前に送信した位置情報に条件を加える
+func (bot *Bot) SearchByText(ctx context.Context, event *linebot.Event, r *nlquery.Result) {
+	if r.Radius > places.MaxRadius {
+		bot.ReplyMessage(ctx, event, TextMessage("距離は"+FormatRadius(strconv.Itoa(places.MaxRadius))+"までで指定してください"))
+		return
+	}
+	scopeKey := NewScope(event.Source).Key()
+	q := Query{}
+	if r.Location != "" {
+		result, err := bot.Geocoder.Geocode(r.Location)
+		if err != nil {
+			log.Print(err)
+			bot.ReplyMessage(ctx, event, TextMessage("場所が見つかりませんでした(´・ω・`)"))
+			return
+		}
+		q = NewQuery(result.Location.Lat, result.Location.Lng)
+		q.Address = shortAddress(result.Address)
+	} else if err := bot.getQuery(ctx, scopeKey, &q); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("位置情報を送信するか，「渋谷駅周辺で安いラーメン」のように場所も入れて送信してください"))
+		return
+	}
+	applyTextConditions(&q, r)
+	if err := mystore.Save(ctx, bot.DatastoreClient, &q, scopeKey, nil); err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("検索条件の保存に失敗しました．\nもう一度送信してくださいm(__)m"))
+		return
+	}
+	confirm := SearchConfirmWindow(&q).WithQuickReplies(KeywordQuickReplyItems(&q))
+	if r.Location != "" {
+		text := fmt.Sprintf("「%s」周辺で検索します", q.Address)
+		bot.replyWithNearFavorites(ctx, event, &q, TextMessage(text), confirm)
+		return
+	}
+	bot.SwitchRichMenu(ctx, event, richmenu.Searching)
+	bot.ReplyMessage(ctx, event, confirm)
+}
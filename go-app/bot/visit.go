@@ -0,0 +1,258 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/recommend"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	// 保存する訪問記録の最大件数
+	MaxVisits int = 100
+	// 「履歴」で表示する件数
+	VisitHistoryCount int = 10
+	// 訪問記録の評価の最大
+	MaxVisitRating int = 5
+	// 同じお店に続けて行かないようにする期間
+	RecentVisitPeriod = 7 * 24 * time.Hour
+)
+
+// 感想を送るときの接頭辞
+const CommentPrefix = "感想:"
+
+// お店に行った記録
+type Visit struct {
+	PlaceID   string    `datastore:"place_id,noindex"`
+	Name      string    `datastore:"name,noindex"`
+	Lat       string    `datastore:"lat,noindex"`
+	Lng       string    `datastore:"lng,noindex"`
+	VisitedAt time.Time `datastore:"visited_at,noindex"`
+	Rating    int       `datastore:"rating,noindex"`
+	Comment   string    `datastore:"comment,noindex"`
+}
+
+// 訪問記録の識別子
+func (v *Visit) ID() int64 {
+	return v.VisitedAt.UnixNano()
+}
+
+// ユーザの訪問記録(新しい順)
+type VisitLog struct {
+	Visits []Visit `datastore:"visits,noindex"`
+	mystore.Timestamp
+}
+
+func (vl *VisitLog) NameKey(name string, parent *datastore.Key) *datastore.Key {
+	name = mystore.HashedString(name)
+	return datastore.NameKey("VisitLog", name, parent)
+}
+
+// 訪問を記録する．古い記録は捨てる
+func (vl *VisitLog) Add(v Visit) {
+	vl.Visits = append([]Visit{v}, vl.Visits...)
+	if len(vl.Visits) > MaxVisits {
+		vl.Visits = vl.Visits[:MaxVisits]
+	}
+}
+
+// 識別子で訪問記録を探す
+func (vl *VisitLog) Find(id int64) *Visit {
+	for i := range vl.Visits {
+		if vl.Visits[i].ID() == id {
+			return &vl.Visits[i]
+		}
+	}
+	return nil
+}
+
+// お店に最後に行った日時
+func (vl *VisitLog) LastVisits() map[string]time.Time {
+	last := map[string]time.Time{}
+	for _, v := range vl.Visits {
+		if t, ok := last[v.PlaceID]; !ok || v.VisitedAt.After(t) {
+			last[v.PlaceID] = v.VisitedAt
+		}
+	}
+	return last
+}
+
+// 期間内に行ったお店
+func (vl *VisitLog) VisitedSince(since time.Time) map[string]bool {
+	visited := map[string]bool{}
+	for _, v := range vl.Visits {
+		if v.VisitedAt.After(since) {
+			visited[v.PlaceID] = true
+		}
+	}
+	return visited
+}
+
+// 訪問記録の操作
+type VisitInfo struct {
+	ID     int64 `json:"id"`
+	Rating int   `json:"rating,omitempty"`
+}
+
+func (v *VisitInfo) PostbackData() {}
+
+// ユーザIDが取れなければ空の記録を返す
+func (bot *Bot) getVisitLog(ctx context.Context, userID string) (*VisitLog, error) {
+	l := VisitLog{}
+	if userID == "" {
+		return &l, nil
+	}
+	err := mystore.Get(ctx, bot.DatastoreClient, &l, userID, nil)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// 検索結果に行ったことがあるか印をつける
+func (bot *Bot) MarkVisited(ctx context.Context, userID string, p places.Places) {
+	l, err := bot.getVisitLog(ctx, userID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	last := l.LastVisits()
+	for i := range p {
+		p[i].VisitedAt = last[p[i].PlaceID]
+	}
+}
+
+// 「行った!」を記録する
+func (bot *Bot) RecordVisit(ctx context.Context, event *linebot.Event, info *PlaceInfo) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	p, err := bot.DetailsSearch(info.PlaceID)
+	if err != nil {
+		log.Print(err)
+		bot.ReplyMessage(ctx, event, TextMessage("記録に失敗しました..."))
+		return
+	}
+	l, err := bot.getVisitLog(ctx, userID)
+	if err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("記録に失敗しました..."))
+		return
+	}
+	// Datastoreはマイクロ秒までしか保存しないので識別子が変わらないように丸める
+	now := time.Now().Truncate(time.Microsecond)
+	text := fmt.Sprintf("「%s」に行った記録をつけました!", p.Name)
+	if last, ok := l.LastVisits()[p.PlaceID]; ok && now.Sub(last) < RecentVisitPeriod {
+		text += fmt.Sprintf("\n(%sにも行っています)", last.In(jst).Format("1/2"))
+	}
+	visit := Visit{
+		PlaceID:   p.PlaceID,
+		Name:      p.Name,
+		Lat:       p.Lat,
+		Lng:       p.Lng,
+		VisitedAt: now,
+	}
+	l.Add(visit)
+	if err := mystore.Save(ctx, bot.DatastoreClient, l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("記録に失敗しました..."))
+		return
+	}
+	bot.learnPreference(ctx, userID, func(pref *recommend.Preference) {
+		pref.LearnPlace(p, visitLearnWeight)
+	})
+	text += "\n評価を選ぶか「" + CommentPrefix + "内容」の形式で感想を送信してネ"
+	bot.ReplyMessage(ctx, event, TextMessage(text).WithQuickReplies(VisitRatingQuickReplyItems(visit.ID())))
+}
+
+// 訪問記録に評価をつける
+func (bot *Bot) RateVisit(ctx context.Context, event *linebot.Event, info *VisitInfo) {
+	if info.Rating < 1 || info.Rating > MaxVisitRating {
+		text := fmt.Sprintf("評価は1〜%dで選んでください", MaxVisitRating)
+		bot.ReplyMessage(ctx, event, TextMessage(text))
+		return
+	}
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l, err := bot.getVisitLog(ctx, userID)
+	if err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("記録に失敗しました..."))
+		return
+	}
+	v := l.Find(info.ID)
+	if v == nil {
+		bot.ReplyMessage(ctx, event, TextMessage("記録が見つかりませんでした"))
+		return
+	}
+	v.Rating = info.Rating
+	if err := mystore.Save(ctx, bot.DatastoreClient, l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("記録に失敗しました..."))
+		return
+	}
+	text := fmt.Sprintf("「%s」を%sで記録しました!", v.Name, strings.Repeat("★", v.Rating))
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+// 最新の訪問記録に感想をつける
+func (bot *Bot) CommentVisit(ctx context.Context, event *linebot.Event, comment string) {
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		bot.ReplyMessage(ctx, event, TextMessage("「"+CommentPrefix+"内容」の形式で続けて送信してネ"))
+		return
+	}
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l, err := bot.getVisitLog(ctx, userID)
+	if err != nil || len(l.Visits) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("お店の「行った!」を選択してから送信してください"))
+		return
+	}
+	v := &l.Visits[0]
+	v.Comment = comment
+	if err := mystore.Save(ctx, bot.DatastoreClient, l, userID, nil); err != nil {
+		bot.ReplyMessage(ctx, event, TextMessage("記録に失敗しました..."))
+		return
+	}
+	text := fmt.Sprintf("「%s」の感想を記録しました!", v.Name)
+	bot.ReplyMessage(ctx, event, TextMessage(text))
+}
+
+// 訪問履歴を表示
+func (bot *Bot) ShowVisits(ctx context.Context, event *linebot.Event) {
+	userID, ok := bot.personalKey(ctx, event)
+	if !ok {
+		return
+	}
+	l, err := bot.getVisitLog(ctx, userID)
+	if err != nil || len(l.Visits) == 0 {
+		bot.ReplyMessage(ctx, event, TextMessage("まだ記録がありません\nお店の「行った!」を選択すると記録されます"))
+		return
+	}
+	bot.ReplyMessage(ctx, event, TextMessage(VisitHistoryText(l.Visits, VisitHistoryCount)))
+}
+
+// 訪問履歴のテキスト
+func VisitHistoryText(visits []Visit, n int) string {
+	str := "行ったお店"
+	for i := 0; i < len(visits) && i < n; i++ {
+		v := visits[i]
+		str += fmt.Sprintf("\n%s %s", v.VisitedAt.In(jst).Format("1/2"), v.Name)
+		if v.Rating > 0 {
+			str += " " + strings.Repeat("★", v.Rating)
+		}
+		if v.Comment != "" {
+			str += "\n  " + truncate(v.Comment, 40)
+		}
+	}
+	return str
+}
@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	mystore "github.com/Fukkatsuso/linebot-restaurant-go/go-app/datastore"
+)
+
+func TestRateVisit(t *testing.T) {
+	ctx := context.Background()
+	visitedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, jst)
+	invalid := []string{fmt.Sprintf("評価は1〜%dで選んでください", MaxVisitRating)}
+	tests := []struct {
+		rating int
+		want   []string
+		saved  int
+	}{
+		{-1, invalid, 0},
+		{0, invalid, 0},
+		{MaxVisitRating + 1, invalid, 0},
+		{4, []string{"「ラーメン屋」を★★★★で記録しました!"}, 4},
+	}
+	for _, tt := range tests {
+		bot, _, line := newTestBot(t)
+		l := VisitLog{Visits: []Visit{{PlaceID: "p1", Name: "ラーメン屋", VisitedAt: visitedAt}}}
+		if err := mystore.Save(ctx, bot.DatastoreClient, &l, "U1", nil); err != nil {
+			t.Fatal(err)
+		}
+		info := VisitInfo{ID: l.Visits[0].ID(), Rating: tt.rating}
+		bot.RateVisit(ctx, userEvent("U1", "rate"), &info)
+		if got := line.ReplyTexts("rate"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rating %d: reply = %q, want %q", tt.rating, got, tt.want)
+		}
+		saved := VisitLog{}
+		if err := mystore.Get(ctx, bot.DatastoreClient, &saved, "U1", nil); err != nil {
+			t.Fatal(err)
+		}
+		if saved.Visits[0].Rating != tt.saved {
+			t.Errorf("rating %d: saved rating = %d, want %d", tt.rating, saved.Visits[0].Rating, tt.saved)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// 定期実行ジョブをローカルで実行する
+//
+//	go run ./cmd/jobs [-notify] refresh-favorites
+//	go run ./cmd/jobs cleanup
+//	go run ./cmd/jobs close-polls
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/bot"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/config"
+)
+
+func main() {
+	notify := flag.Bool("notify", false, "閉業したお店をユーザに通知する")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <job>\n\njobs:\n  refresh-favorites\n  cleanup\n  close-polls\n\nflags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	dsClient, err := datastore.NewClient(ctx, config.DatastoreProjectID)
+	if err != nil {
+		log.Fatalf("Could not create datastore client: %v", err)
+	}
+	defer dsClient.Close()
+
+	lineBot, err := bot.NewLINEClient(config.LINEChannelSecret, config.LINEChannelToken, config.LINEAPIEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b := bot.NewBot(lineBot, dsClient, bot.Settings{
+		GCPPlacesAPIKey: config.GCPPlacesAPIKey,
+		Name:            config.LINEBotName,
+		BaseURL:         config.BaseURL,
+		URLSigningKey:   config.URLSigningKey,
+		JobToken:        config.JobToken,
+	})
+
+	var result interface{}
+	switch flag.Arg(0) {
+	case "refresh-favorites":
+		result, err = b.RefreshFavorites(ctx, *notify)
+	case "cleanup":
+		result, err = b.CleanupExpired(ctx)
+	case "close-polls":
+		result, err = b.ClosePolls(ctx)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
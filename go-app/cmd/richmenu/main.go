@@ -0,0 +1,91 @@
+// リッチメニューを管理する
+//
+//	go run ./cmd/richmenu [-images dir] sync
+//	go run ./cmd/richmenu list
+//	go run ./cmd/richmenu link <userID> <kind>
+//	go run ./cmd/richmenu unlink <userID>
+//	go run ./cmd/richmenu delete
+//	go run ./cmd/richmenu [-addr :8081] stand-in
+//
+// LINE_API_ENDPOINT=http://localhost:8081 をつけると stand-in で起動した代役サーバに向く
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/bot"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/config"
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/richmenu"
+)
+
+func main() {
+	images := flag.String("images", config.RichMenuImageDir, "メニュー画像(<種類>.png)のディレクトリ．なければ色分けした仮の画像を使う")
+	addr := flag.String("addr", ":8081", "代役サーバのアドレス")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <command> [args]\n\ncommands:\n  sync\n  list\n  link <userID> <kind>\n  unlink <userID>\n  delete\n  stand-in\n\nflags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if flag.Arg(0) == "stand-in" {
+		log.Printf("rich menu stand-in server listening on %s", *addr)
+		log.Fatal(http.ListenAndServe(*addr, richmenu.NewStandInServer()))
+	}
+
+	ctx := context.Background()
+	client, err := bot.NewLINEClient(config.LINEChannelSecret, config.LINEChannelToken, config.LINEAPIEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var result interface{}
+	switch flag.Arg(0) {
+	case "sync":
+		result, err = richmenu.Sync(ctx, client, *images)
+	case "list":
+		result, err = client.GetRichMenuList().WithContext(ctx).Do()
+	case "link":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var ids map[string]string
+		ids, err = richmenu.Lookup(ctx, client)
+		if err == nil {
+			id, ok := ids[flag.Arg(2)]
+			if !ok {
+				log.Fatalf("rich menu %q is not created. run sync first", flag.Arg(2))
+			}
+			result, err = client.LinkUserRichMenu(flag.Arg(1), id).WithContext(ctx).Do()
+		}
+	case "unlink":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		result, err = client.UnlinkUserRichMenu(flag.Arg(1)).WithContext(ctx).Do()
+	case "delete":
+		var n int
+		n, err = richmenu.Delete(ctx, client)
+		result = map[string]int{"deleted": n}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strings"
 )
 
 // LINE
@@ -10,12 +11,21 @@ var (
 	LINEChannelID     string
 	LINEChannelSecret string
 	LINEChannelToken  string
+	// グループでメンションされたか判定するための表示名
+	LINEBotName string
+	// Messaging APIの向き先．ローカルの代役サーバで試すときに設定する
+	LINEAPIEndpoint string
+	// cmd/richmenu でリッチメニューを作成・更新するときの画像のディレクトリ
+	RichMenuImageDir string
 )
 
 func initEnvLINE() {
 	LINEChannelID = os.Getenv("LINE_CHANNEL_ID")
 	LINEChannelSecret = os.Getenv("LINE_CHANNEL_SECRET")
 	LINEChannelToken = os.Getenv("LINE_CHANNEL_TOKEN")
+	LINEBotName = os.Getenv("LINE_BOT_NAME")
+	LINEAPIEndpoint = os.Getenv("LINE_API_ENDPOINT")
+	RichMenuImageDir = os.Getenv("RICH_MENU_IMAGE_DIR")
 }
 
 // GCP
@@ -32,7 +42,24 @@ func initEnvGCP() {
 	}
 }
 
+// Server
+var (
+	// 署名付きURLを組み立てるための公開URL(例: https://example.com)
+	BaseURL string
+	// URLの署名鍵．未設定ならエクスポート・インポートのリンクは無効
+	URLSigningKey string
+	// 定期実行ジョブのエンドポイントの認証トークン．未設定なら無効
+	JobToken string
+)
+
+func initEnvServer() {
+	BaseURL = strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	URLSigningKey = os.Getenv("URL_SIGNING_KEY")
+	JobToken = os.Getenv("JOB_TOKEN")
+}
+
 func init() {
 	initEnvLINE()
 	initEnvGCP()
+	initEnvServer()
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"log"
+	"time"
 
 	"cloud.google.com/go/datastore"
 )
@@ -24,6 +25,9 @@ func Get(ctx context.Context, client *datastore.Client, entity Entity, name stri
 
 // Save Entity in Datastore
 func Save(ctx context.Context, client *datastore.Client, entity Entity, name string, parent *datastore.Key) error {
+	if t, ok := entity.(Touchable); ok {
+		t.Touch(time.Now())
+	}
 	key := entity.NameKey(name, parent)
 	_, err := client.Put(ctx, key, entity)
 	log.Println("[Save]", entity, err)
@@ -38,6 +42,47 @@ func Delete(ctx context.Context, client *datastore.Client, entity Entity, name s
 	return err
 }
 
+// MaxEntitySize is the maximum size of an entity in Datastore
+const MaxEntitySize = 1 << 20
+
+// Size estimates the stored size of Entity in bytes
+func Size(entity Entity) (int, error) {
+	props, err := datastore.SaveStruct(entity)
+	if err != nil {
+		return 0, err
+	}
+	return propertiesSize(props), nil
+}
+
+func propertiesSize(props []datastore.Property) int {
+	size := 0
+	for _, p := range props {
+		size += len(p.Name) + valueSize(p.Value)
+	}
+	return size
+}
+
+func valueSize(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return len(v) + 1
+	case []byte:
+		return len(v)
+	case []interface{}:
+		size := 0
+		for _, e := range v {
+			size += valueSize(e)
+		}
+		return size
+	case *datastore.Entity:
+		return propertiesSize(v.Properties)
+	case *datastore.Key:
+		return len(v.String())
+	}
+	// 数値や真偽値，日時など
+	return 8
+}
+
 // sha256でハッシュ化して64文字の文字列にする
 func HashedString(base string) string {
 	hashBytes := sha256.Sum256([]byte(base))
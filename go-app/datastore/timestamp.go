@@ -0,0 +1,19 @@
+package datastore
+
+import "time"
+
+// Timestamp records when the entity was last saved.
+// エンティティに埋め込むとSaveで更新日時が記録される
+type Timestamp struct {
+	UpdatedAt time.Time `datastore:"updated_at"`
+}
+
+// Touch sets the saved time
+func (t *Timestamp) Touch(now time.Time) {
+	t.UpdatedAt = now
+}
+
+// Touchable is an entity which records the saved time
+type Touchable interface {
+	Touch(now time.Time)
+}
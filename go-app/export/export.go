@@ -0,0 +1,233 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 書き出すお店1件分
+type Record struct {
+	Name     string
+	Lat      string
+	Lng      string
+	Address  string
+	URL      string
+	Tags     []string
+	Memo     string
+	MyRating int
+}
+
+// 書き出し形式
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatGeoJSON Format = "geojson"
+	FormatKML     Format = "kml"
+)
+
+// 対応している形式
+var Formats = []Format{FormatCSV, FormatGeoJSON, FormatKML}
+
+// ErrUnknownFormat errors
+var ErrUnknownFormat = errors.New("export: unknown format")
+
+// ParseFormat converts string to Format
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == strings.ToLower(s) {
+			return f, nil
+		}
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType returns MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatGeoJSON:
+		return "application/geo+json"
+	case FormatKML:
+		return "application/vnd.google-earth.kml+xml"
+	}
+	return "application/octet-stream"
+}
+
+// Filename returns download file name
+func (f Format) Filename(name string) string {
+	return name + "." + string(f)
+}
+
+// Write writes records in the format
+func Write(w io.Writer, f Format, records []Record) error {
+	switch f {
+	case FormatCSV:
+		return WriteCSV(w, records)
+	case FormatGeoJSON:
+		return WriteGeoJSON(w, records)
+	case FormatKML:
+		return WriteKML(w, records)
+	}
+	return ErrUnknownFormat
+}
+
+// メモやタグをまとめた説明文
+func (r *Record) Description() string {
+	lines := []string{}
+	if r.Address != "" {
+		lines = append(lines, r.Address)
+	}
+	if len(r.Tags) > 0 {
+		lines = append(lines, "#"+strings.Join(r.Tags, " #"))
+	}
+	if r.MyRating > 0 {
+		lines = append(lines, fmt.Sprintf("じぶん評価: %d", r.MyRating))
+	}
+	if r.Memo != "" {
+		lines = append(lines, r.Memo)
+	}
+	if r.URL != "" {
+		lines = append(lines, r.URL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+var csvHeader = []string{"name", "lat", "lng", "address", "url", "tags", "memo", "my_rating"}
+
+// WriteCSV writes records as CSV
+func WriteCSV(w io.Writer, records []Record) error {
+	// Excelで文字化けしないようにBOMをつける
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		rating := ""
+		if r.MyRating > 0 {
+			rating = strconv.Itoa(r.MyRating)
+		}
+		row := []string{r.Name, r.Lat, r.Lng, r.Address, r.URL, strings.Join(r.Tags, " "), r.Memo, rating}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   *geoJSONPoint          `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// 座標が読めなければnil
+func point(lat, lng string) *geoJSONPoint {
+	la, err1 := strconv.ParseFloat(lat, 64)
+	ln, err2 := strconv.ParseFloat(lng, 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	// GeoJSONは経度,緯度の順
+	return &geoJSONPoint{Type: "Point", Coordinates: []float64{ln, la}}
+}
+
+// WriteGeoJSON writes records as GeoJSON FeatureCollection
+func WriteGeoJSON(w io.Writer, records []Record) error {
+	fc := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []geoJSONFeature{},
+	}
+	for _, r := range records {
+		props := map[string]interface{}{
+			"name":    r.Name,
+			"address": r.Address,
+			"url":     r.URL,
+			"tags":    r.Tags,
+			"memo":    r.Memo,
+		}
+		if r.Tags == nil {
+			props["tags"] = []string{}
+		}
+		if r.MyRating > 0 {
+			props["my_rating"] = r.MyRating
+		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   point(r.Lat, r.Lng),
+			Properties: props,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
+
+type kml struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string    `xml:"name"`
+	Address     string    `xml:"address,omitempty"`
+	Description string    `xml:"description,omitempty"`
+	Point       *kmlPoint `xml:"Point,omitempty"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// WriteKML writes records as KML document
+func WriteKML(w io.Writer, records []Record) error {
+	doc := kml{Document: kmlDocument{Name: "お気に入り"}}
+	for _, r := range records {
+		pm := kmlPlacemark{
+			Name:        r.Name,
+			Address:     r.Address,
+			Description: r.Description(),
+		}
+		if r.Lat != "" && r.Lng != "" {
+			// KMLも経度,緯度の順
+			pm.Point = &kmlPoint{Coordinates: r.Lng + "," + r.Lat}
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, pm)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
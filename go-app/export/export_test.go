@@ -0,0 +1,180 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/takeout"
+)
+
+// 記号を含むお店
+func testRecords() []Record {
+	return []Record{
+		{
+			Name:     `Tom & Jerry's <Cafe> "本店"`,
+			Lat:      "35.681236",
+			Lng:      "139.767125",
+			Address:  "東京都千代田区丸の内1丁目",
+			URL:      "https://www.google.com/maps/search/?api=1&query=x&query_place_id=ChIJ1",
+			Tags:     []string{"ランチ", "R&B"},
+			Memo:     "a < b && c > d\n2行目, \"引用\"",
+			MyRating: 4,
+		},
+		// 座標のないお店
+		{Name: "座標なし"},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range Formats {
+		got, err := ParseFormat(strings.ToUpper(string(f)))
+		if err != nil || got != f {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", f, got, err, f)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err != ErrUnknownFormat {
+		t.Errorf("ParseFormat(xlsx) error = %v, want %v", err, ErrUnknownFormat)
+	}
+	if err := Write(&bytes.Buffer{}, Format("xlsx"), testRecords()); err != ErrUnknownFormat {
+		t.Errorf("Write(xlsx) error = %v, want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := Write(&buf, FormatCSV, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "\ufeff") {
+		t.Error("CSV has no BOM")
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testRecords()[0]
+	want := [][]string{
+		csvHeader,
+		{r.Name, r.Lat, r.Lng, r.Address, r.URL, "ランチ R&B", r.Memo, "4"},
+		{"座標なし", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := Write(&buf, FormatGeoJSON, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry *struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				Name     string   `json:"name"`
+				Tags     []string `json:"tags"`
+				Memo     string   `json:"memo"`
+				MyRating int      `json:"my_rating"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v\n%s", err, buf.String())
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+		t.Fatalf("got %+v, want 2 features", fc)
+	}
+	r := testRecords()[0]
+	f := fc.Features[0]
+	if f.Type != "Feature" || f.Geometry == nil || f.Geometry.Type != "Point" || !reflect.DeepEqual(f.Geometry.Coordinates, []float64{139.767125, 35.681236}) {
+		t.Errorf("feature = %+v, want a point at lng,lat", f)
+	}
+	if p := f.Properties; p.Name != r.Name || p.Memo != r.Memo || !reflect.DeepEqual(p.Tags, r.Tags) || p.MyRating != r.MyRating {
+		t.Errorf("properties = %+v, want %+v", p, r)
+	}
+	// 座標がなければ geometry は null，タグは空の配列
+	if f := fc.Features[1]; f.Geometry != nil || f.Properties.Tags == nil {
+		t.Errorf("feature without location = %+v", f)
+	}
+}
+
+func TestWriteKML(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := Write(&buf, FormatKML, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, xml.Header) {
+		t.Errorf("KML has no XML header: %s", out)
+	}
+	// 記号はエスケープされる
+	for _, raw := range []string{"<Cafe>", "& Jerry", "a < b", "&& c", "R&B"} {
+		if strings.Contains(out, raw) {
+			t.Errorf("KML contains unescaped %q", raw)
+		}
+	}
+
+	// 整形式のXMLとして読み戻せる
+	var doc kml
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid KML: %v\n%s", err, out)
+	}
+	if doc.XMLName.Space != "http://www.opengis.net/kml/2.2" {
+		t.Errorf("namespace = %q", doc.XMLName.Space)
+	}
+	pms := doc.Document.Placemarks
+	if len(pms) != 2 {
+		t.Fatalf("%d placemarks, want 2", len(pms))
+	}
+	r := testRecords()[0]
+	if pms[0].Name != r.Name || pms[0].Address != r.Address || pms[0].Description != r.Description() {
+		t.Errorf("placemark = %+v, want %+v", pms[0], r)
+	}
+	if pms[0].Point == nil || pms[0].Point.Coordinates != "139.767125,35.681236" {
+		t.Errorf("point = %+v, want lng,lat", pms[0].Point)
+	}
+	if pms[1].Point != nil {
+		t.Errorf("point without location = %+v, want none", pms[1].Point)
+	}
+}
+
+func TestDescription(t *testing.T) {
+	r := testRecords()[0]
+	want := strings.Join([]string{r.Address, "#ランチ #R&B", "じぶん評価: 4", r.Memo, r.URL}, "\n")
+	if got := r.Description(); got != want {
+		t.Errorf("Description() = %q, want %q", got, want)
+	}
+	if got := (&Record{Name: "座標なし"}).Description(); got != "" {
+		t.Errorf("Description() = %q, want empty", got)
+	}
+}
+
+// 書き出したファイルはインポートで読み戻せる
+func TestRoundTripWithTakeout(t *testing.T) {
+	r := testRecords()[0]
+	want := takeout.Entry{Name: r.Name, Address: r.Address, Lat: r.Lat, Lng: r.Lng, URL: r.URL, PlaceID: "ChIJ1", Note: r.Memo, Tags: r.Tags, MyRating: r.MyRating}
+	for _, f := range []Format{FormatCSV, FormatGeoJSON} {
+		buf := bytes.Buffer{}
+		if err := Write(&buf, f, testRecords()); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := takeout.Parse(f.Filename("favorites"), &buf)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if len(entries) != 2 || !reflect.DeepEqual(entries[0], want) {
+			t.Errorf("%s: entries = %+v, want %+v first", f, entries, want)
+		}
+	}
+}
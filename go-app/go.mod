@@ -4,5 +4,9 @@ go 1.14
 
 require (
 	cloud.google.com/go/datastore v1.3.0
+	github.com/golang/protobuf v1.4.2
 	github.com/line/line-bot-sdk-go v7.5.0+incompatible
+	golang.org/x/text v0.3.3
+	google.golang.org/genproto v0.0.0-20200916143405-f6a2fa72f0c4
+	google.golang.org/grpc v1.32.0
 )
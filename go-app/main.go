@@ -9,7 +9,6 @@ import (
 	"cloud.google.com/go/datastore"
 	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/bot"
 	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/config"
-	"github.com/line/line-bot-sdk-go/linebot"
 )
 
 func main() {
@@ -22,14 +21,26 @@ func main() {
 	}
 	defer dsClient.Close()
 
-	lineBot, err := linebot.New(config.LINEChannelSecret, config.LINEChannelToken)
+	lineBot, err := bot.NewLINEClient(config.LINEChannelSecret, config.LINEChannelToken, config.LINEAPIEndpoint)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	bot := bot.NewBot(lineBot, dsClient, config.GCPPlacesAPIKey)
+	bot := bot.NewBot(lineBot, dsClient, bot.Settings{
+		GCPPlacesAPIKey: config.GCPPlacesAPIKey,
+		Name:            config.LINEBotName,
+		BaseURL:         config.BaseURL,
+		URLSigningKey:   config.URLSigningKey,
+		JobToken:        config.JobToken,
+	})
+	bot.LoadRichMenus(ctx)
 
 	http.HandleFunc("/callback", bot.CallbackHandler())
+	http.HandleFunc("/export", bot.ExportHandler())
+	http.HandleFunc("/import", bot.ImportHandler())
+	http.HandleFunc("/jobs/refresh-favorites", bot.RefreshFavoritesHandler())
+	http.HandleFunc("/jobs/cleanup", bot.CleanupHandler())
+	http.HandleFunc("/jobs/close-polls", bot.ClosePollsHandler())
 
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,253 @@
+package nlquery
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// 文から取り出した検索条件
+type Result struct {
+	// 距離(m)．0は指定なし
+	Radius int
+	// 価格帯の上限(1〜4)．0は指定なし
+	MaxPrice int
+	// 今営業しているお店だけ
+	OpenNow bool
+	// お店の種類(Places APIのtype)
+	Type string
+	// 検索地点の名前
+	Location string
+	// 残りの語
+	Keywords []string
+}
+
+// 距離・価格・営業中・種類のいずれかが指定されたか
+func (r *Result) HasConditions() bool {
+	return r.Radius > 0 || r.MaxPrice > 0 || r.OpenNow || r.Type != ""
+}
+
+// 文を解析するルール．一致した部分は文から取り除かれる
+type rule struct {
+	pattern *regexp.Regexp
+	apply   func(m []string, r *Result)
+}
+
+// 1分で歩く距離(m)
+const walkingMetersPerMinute = 80
+
+// 語の区切りになる記号と助詞(「の」は「お茶の水」のように地名に含まれるので除く)
+const (
+	boundary    = `[\s、。,，!！?？をがはでにと]`
+	notBoundary = `[^\s、。,，!！?？をがはでにと]`
+)
+
+var rules = []rule{
+	// 距離: 「1km以内」「500m」「2キロ圏内」
+	{
+		pattern: regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(km|キロ(?:メートル)?|m|メートル)\s*(?:以内|圏内|くらい|ぐらい|まで)?(?:で|の)?`),
+		apply: func(m []string, r *Result) {
+			v, _ := strconv.ParseFloat(m[1], 64)
+			if strings.HasPrefix(m[2], "k") || strings.HasPrefix(m[2], "キロ") {
+				v *= 1000
+			}
+			r.Radius = int(v)
+		},
+	},
+	// 距離: 「歩いて10分」「徒歩5分以内」
+	{
+		pattern: regexp.MustCompile(`(?:歩いて|徒歩)\s*(\d+)\s*分\s*(?:以内|圏内|くらい|ぐらい|まで)?(?:で|の)?`),
+		apply: func(m []string, r *Result) {
+			min, _ := strconv.Atoi(m[1])
+			r.Radius = min * walkingMetersPerMinute
+		},
+	},
+	// 価格: 「2000円以下」「予算3000円」
+	{
+		pattern: regexp.MustCompile(`(?:予算)?\s*(\d+)\s*円\s*(?:以下|以内|まで|くらい|ぐらい)?(?:で|の)?`),
+		apply: func(m []string, r *Result) {
+			yen, _ := strconv.Atoi(m[1])
+			r.MaxPrice = PriceLevel(yen)
+		},
+	},
+	// 価格: 「安い」「リーズナブルな」
+	{
+		pattern: regexp.MustCompile(`(?:安い|安め|やすい|リーズナブル|お手頃|手頃|格安)な?`),
+		apply: func(m []string, r *Result) {
+			r.MaxPrice = 1
+		},
+	},
+	// 営業中: 「今開いてる」「営業中の」
+	{
+		pattern: regexp.MustCompile(`(?:今|いま)?\s*(?:開いてる|開いている|空いてる|あいてる|やってる|やっている|営業中)(?:の|で)?`),
+		apply: func(m []string, r *Result) {
+			r.OpenNow = true
+		},
+	},
+	// 検索地点: 「渋谷駅周辺で」「新宿の近くの」
+	// 地名は文頭か区切り・助詞の直後から始まるものとする(「ラーメンを渋谷駅周辺で」)
+	{
+		pattern: regexp.MustCompile(`(?:^|` + boundary + `)(` + notBoundary + `+?)の?(?:周辺|付近|近く|あたり|辺り)(?:で|の|に)?`),
+		apply: func(m []string, r *Result) {
+			r.Location = m[1]
+		},
+	},
+	// 検索地点: 「渋谷駅で」
+	{
+		pattern: regexp.MustCompile(`(?:^|` + boundary + `)(` + notBoundary + `+駅)(?:で|の|に)`),
+		apply: func(m []string, r *Result) {
+			r.Location = m[1]
+		},
+	},
+}
+
+// 種類を表す語とPlaces APIのtype
+var types = []struct {
+	words []string
+	typ   string
+}{
+	{[]string{"カフェ", "喫茶店", "喫茶", "コーヒー"}, "cafe"},
+	{[]string{"バー"}, "bar"},
+	{[]string{"パン屋", "ベーカリー"}, "bakery"},
+	{[]string{"テイクアウト", "持ち帰り"}, "meal_takeaway"},
+	{[]string{"デリバリー", "出前"}, "meal_delivery"},
+}
+
+// TypeLabel returns the display name of the place type
+func TypeLabel(typ string) string {
+	for _, t := range types {
+		if t.typ == typ {
+			return t.words[0]
+		}
+	}
+	return typ
+}
+
+// TypeOf returns the place type named by the word
+func TypeOf(word string) (string, bool) {
+	for _, t := range types {
+		for _, w := range t.words {
+			if word == w {
+				return t.typ, true
+			}
+		}
+	}
+	return "", false
+}
+
+// キーワードとしては意味のない言い回し．どこにあっても取り除く
+var phrases = []string{
+	"を探して", "探して", "さがして", "を教えて", "教えて", "おすすめの", "おすすめ", "オススメ", "お店", "ください",
+}
+
+// 語の末尾につく意味のない語．この順にそれぞれ1度だけ取り除く(「ラーメン店ある」)
+var suffixes = []string{"ありますか", "ある", "店"}
+
+// 語の末尾の助詞
+var particles = []string{"で", "の", "が", "を", "に", "は"}
+
+var separator = regexp.MustCompile(`[\s、。,，!！?？]+`)
+
+// PriceLevel converts budget (yen) to price level of Places API
+func PriceLevel(yen int) int {
+	switch {
+	case yen <= 0:
+		return 0
+	case yen <= 1000:
+		return 1
+	case yen <= 3000:
+		return 2
+	case yen <= 8000:
+		return 3
+	}
+	return 4
+}
+
+// Parse extracts search conditions from a free-form sentence
+func Parse(text string) Result {
+	r := Result{}
+	// 全角英数字を半角にそろえる
+	text = width.Fold.String(text)
+
+	for _, rule := range rules {
+		m := rule.pattern.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		groups := make([]string, len(m)/2)
+		for i := range groups {
+			if m[2*i] >= 0 {
+				groups[i] = text[m[2*i]:m[2*i+1]]
+			}
+		}
+		rule.apply(groups, &r)
+		text = text[:m[0]] + " " + text[m[1]:]
+	}
+
+	for _, chunk := range separator.Split(text, -1) {
+		for _, p := range phrases {
+			chunk = strings.Replace(chunk, p, " ", -1)
+		}
+		for _, word := range strings.Fields(chunk) {
+			word = trimFillers(word)
+			if r.Type == "" {
+				if typ, rest, ok := matchType(word); ok {
+					r.Type = typ
+					word = trimFillers(rest)
+				}
+			}
+			if word != "" {
+				r.Keywords = append(r.Keywords, word)
+			}
+		}
+	}
+	return r
+}
+
+// 種類を表す語と一致するか．「新宿のカフェ」のように「の」「な」の後に続く場合も種類とみなし，前の部分を返す
+// (「ハンバーガー」の「バー」のような語の一部は種類とみなさない)
+func matchType(word string) (string, string, bool) {
+	for _, t := range types {
+		for _, w := range t.words {
+			if word == w {
+				return t.typ, "", true
+			}
+			if !strings.HasSuffix(word, w) {
+				continue
+			}
+			rest := strings.TrimSuffix(word, w)
+			for _, p := range []string{"の", "な"} {
+				if strings.HasSuffix(rest, p) {
+					return t.typ, strings.TrimSuffix(rest, p), true
+				}
+			}
+		}
+	}
+	return "", "", false
+}
+
+// 語の末尾の意味のない語と助詞を1度ずつ取り除く．
+// 助詞は前に語が残るときだけ取り除く(「かに」の「に」は取り除かない)
+func trimFillers(word string) string {
+	for _, s := range suffixes {
+		word = strings.TrimSuffix(word, s)
+	}
+	for _, p := range particles {
+		if word == p {
+			return ""
+		}
+		if !strings.HasSuffix(word, p) {
+			continue
+		}
+		rest := []rune(strings.TrimSuffix(word, p))
+		// ひらがな1文字の後の助詞は語の一部とみなす
+		if len(rest) >= 2 || !unicode.In(rest[0], unicode.Hiragana) {
+			word = string(rest)
+		}
+		break
+	}
+	return word
+}
@@ -0,0 +1,135 @@
+package nlquery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want Result
+	}{
+		// 距離
+		{"1km以内", Result{Radius: 1000}},
+		{"500mのラーメン", Result{Radius: 500, Keywords: []string{"ラーメン"}}},
+		{"１．５ｋｍ以内", Result{Radius: 1500}},
+		{"2キロ圏内でカレー", Result{Radius: 2000, Keywords: []string{"カレー"}}},
+		{"徒歩5分以内のラーメン", Result{Radius: 400, Keywords: []string{"ラーメン"}}},
+		// 価格
+		{"安い", Result{MaxPrice: 1}},
+		{"安いラーメン", Result{MaxPrice: 1, Keywords: []string{"ラーメン"}}},
+		{"2000円以下", Result{MaxPrice: 2}},
+		{"予算3000円でおすすめのお店を教えて", Result{MaxPrice: 2}},
+		// 営業中
+		{"今開いてる", Result{OpenNow: true}},
+		{"今開いてるカフェ", Result{OpenNow: true, Type: "cafe"}},
+		{"営業中の焼肉", Result{OpenNow: true, Keywords: []string{"焼肉"}}},
+		// 種類
+		{"カフェ", Result{Type: "cafe"}},
+		{"カフェで", Result{Type: "cafe"}},
+		{"新宿のカフェ", Result{Type: "cafe", Keywords: []string{"新宿"}}},
+		{"おしゃれなカフェ", Result{Type: "cafe", Keywords: []string{"おしゃれ"}}},
+		{"2000円以下のパン屋", Result{MaxPrice: 2, Type: "bakery"}},
+		// 語の一部は種類とみなさない
+		{"ハンバーガー", Result{Keywords: []string{"ハンバーガー"}}},
+		{"ワインバー", Result{Keywords: []string{"ワインバー"}}},
+		// 検索地点
+		{"渋谷駅周辺", Result{Location: "渋谷駅"}},
+		{"渋谷駅周辺で1km以内の安いラーメン", Result{Radius: 1000, MaxPrice: 1, Location: "渋谷駅", Keywords: []string{"ラーメン"}}},
+		{"安いラーメンを渋谷駅周辺で", Result{MaxPrice: 1, Location: "渋谷駅", Keywords: []string{"ラーメン"}}},
+		{"ラーメンを渋谷駅で", Result{Location: "渋谷駅", Keywords: []string{"ラーメン"}}},
+		{"お茶の水の近くのカフェ", Result{Type: "cafe", Location: "お茶の水"}},
+		{"新宿の辺りで寿司", Result{Location: "新宿", Keywords: []string{"寿司"}}},
+		// 助詞や意味のない語
+		{"かに", Result{Keywords: []string{"かに"}}},
+		{"うに", Result{Keywords: []string{"うに"}}},
+		{"のり弁", Result{Keywords: []string{"のり弁"}}},
+		{"牛で", Result{Keywords: []string{"牛"}}},
+		{"すしを", Result{Keywords: []string{"すし"}}},
+		{"ラーメン店ある?", Result{Keywords: []string{"ラーメン"}}},
+		{"ラーメン つけ麺", Result{Keywords: []string{"ラーメン", "つけ麺"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasConditions(t *testing.T) {
+	tests := []struct {
+		r    Result
+		want bool
+	}{
+		{Result{}, false},
+		{Result{Keywords: []string{"ラーメン"}, Location: "渋谷"}, false},
+		{Result{Radius: 500}, true},
+		{Result{MaxPrice: 1}, true},
+		{Result{OpenNow: true}, true},
+		{Result{Type: "cafe"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.r.HasConditions(); got != tt.want {
+			t.Errorf("%+v.HasConditions() = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestPriceLevel(t *testing.T) {
+	tests := []struct {
+		yen  int
+		want int
+	}{
+		{0, 0},
+		{500, 1},
+		{1000, 1},
+		{1001, 2},
+		{3000, 2},
+		{8000, 3},
+		{10000, 4},
+	}
+	for _, tt := range tests {
+		if got := PriceLevel(tt.yen); got != tt.want {
+			t.Errorf("PriceLevel(%d) = %d, want %d", tt.yen, got, tt.want)
+		}
+	}
+}
+
+func TestTypeLabel(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"cafe", "カフェ"},
+		{"bar", "バー"},
+		{"restaurant", "restaurant"},
+	}
+	for _, tt := range tests {
+		if got := TypeLabel(tt.typ); got != tt.want {
+			t.Errorf("TypeLabel(%q) = %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		word string
+		typ  string
+		ok   bool
+	}{
+		{"カフェ", "cafe", true},
+		{"喫茶", "cafe", true},
+		{"パン屋", "bakery", true},
+		{"ハンバーガー", "", false},
+		{"新宿のカフェ", "", false},
+	}
+	for _, tt := range tests {
+		typ, ok := TypeOf(tt.word)
+		if typ != tt.typ || ok != tt.ok {
+			t.Errorf("TypeOf(%q) = %q, %v, want %q, %v", tt.word, typ, ok, tt.typ, tt.ok)
+		}
+	}
+}
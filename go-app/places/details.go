@@ -1,5 +1,10 @@
 package places
 
+import "errors"
+
+// ErrPlaceNotFound errors
+var ErrPlaceNotFound = errors.New("details: not found")
+
 // PlaceDetails is a response of details
 type PlaceDetails struct {
 	HTMLAttributions []interface{} `json:"html_attributions"`
@@ -7,6 +12,17 @@ type PlaceDetails struct {
 	Status           string        `json:"status"`
 }
 
+// Found returns the result if the place was found
+func (d *PlaceDetails) Found() (*Details, error) {
+	switch d.Status {
+	case "OK":
+		return &d.Result, nil
+	case "NOT_FOUND", "ZERO_RESULTS":
+		return nil, ErrPlaceNotFound
+	}
+	return nil, errors.New("details: " + d.Status)
+}
+
 // Details is a part of format of API response
 type Details struct {
 	AddressComponents []struct {
@@ -46,8 +62,9 @@ type Details struct {
 		PhotoReference   string   `json:"photo_reference"`
 		Width            int      `json:"width"`
 	} `json:"photos"`
-	PlaceID  string `json:"place_id"`
-	PlusCode struct {
+	PlaceID    string `json:"place_id"`
+	PriceLevel int    `json:"price_level"`
+	PlusCode   struct {
 		CompoundCode string `json:"compound_code"`
 		GlobalCode   string `json:"global_code"`
 	} `json:"plus_code"`
@@ -73,9 +90,24 @@ type Details struct {
 // MarshalPlace converts Details to Place
 func (p *Details) MarshalPlace() Place {
 	return Place{
-		PlaceID:      p.PlaceID,
-		Name:         p.Name,
-		Rating:       p.Rating,
-		GooglemapURI: p.URL,
+		PlaceID:        p.PlaceID,
+		Name:           p.Name,
+		Rating:         p.Rating,
+		GooglemapURI:   p.URL,
+		Lat:            p.Geometry.Location.Lat,
+		Lng:            p.Geometry.Location.Lng,
+		Address:        p.FormattedAddress,
+		BusinessStatus: p.BusinessStatus,
+		Types:          p.Types,
+		PriceLevel:     p.PriceLevel,
+	}
+}
+
+// PhotoURI returns uri
+func (p *Details) PhotoURI(params map[string]string) string {
+	if len(p.Photos) == 0 {
+		return AlternativePhotoURI()
 	}
+	params["photoreference"] = p.Photos[0].PhotoReference
+	return GooglemapPhotoURI(params)
 }
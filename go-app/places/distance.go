@@ -0,0 +1,183 @@
+package places
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 地球の半径(m)
+const earthRadius = 6371000
+
+// Distance returns great-circle distance between a and b in meters
+func Distance(a, b LatLng) (float64, error) {
+	lat1, err := strconv.ParseFloat(a.Lat, 64)
+	if err != nil {
+		return 0, err
+	}
+	lng1, err := strconv.ParseFloat(a.Lng, 64)
+	if err != nil {
+		return 0, err
+	}
+	lat2, err := strconv.ParseFloat(b.Lat, 64)
+	if err != nil {
+		return 0, err
+	}
+	lng2, err := strconv.ParseFloat(b.Lng, 64)
+	if err != nil {
+		return 0, err
+	}
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLng := rad(lng2 - lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h)), nil
+}
+
+// TravelMode is a mode of transport
+type TravelMode string
+
+// TravelMode
+const (
+	TravelModeWalking TravelMode = "walking"
+	TravelModeTransit TravelMode = "transit"
+)
+
+// Label returns japanese name of the mode
+func (m TravelMode) Label() string {
+	switch m {
+	case TravelModeWalking:
+		return "徒歩"
+	case TravelModeTransit:
+		return "電車"
+	}
+	return ""
+}
+
+// TravelTime is a duration from origin to the place
+type TravelTime struct {
+	Mode     TravelMode
+	Duration time.Duration
+	Text     string
+}
+
+// Valid reports whether the travel time is available
+func (t TravelTime) Valid() bool {
+	return t.Mode != "" && t.Text != ""
+}
+
+// DistanceMatrixProvider returns travel times from origin to each destination
+type DistanceMatrixProvider interface {
+	TravelTimes(origin LatLng, destinations []LatLng, mode TravelMode) ([]TravelTime, error)
+}
+
+// MaxDestinations is the maximum number of destinations per request
+const MaxDestinations = 25
+
+// DistanceMatrix is a response of distance-matrix
+type DistanceMatrix struct {
+	DestinationAddresses []string `json:"destination_addresses"`
+	OriginAddresses      []string `json:"origin_addresses"`
+	Rows                 []struct {
+		Elements []DistanceMatrixElement `json:"elements"`
+	} `json:"rows"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// DistanceMatrixElement is a part of format of API response
+type DistanceMatrixElement struct {
+	Distance struct {
+		Text  string `json:"text"`
+		Value int    `json:"value"`
+	} `json:"distance"`
+	Duration struct {
+		Text  string `json:"text"`
+		Value int    `json:"value"`
+	} `json:"duration"`
+	Status string `json:"status"`
+}
+
+// GoogleDistanceMatrix is DistanceMatrixProvider using Google Distance Matrix API
+type GoogleDistanceMatrix struct {
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// NewGoogleDistanceMatrix returns GoogleDistanceMatrix
+func NewGoogleDistanceMatrix(apiKey string) *GoogleDistanceMatrix {
+	return &GoogleDistanceMatrix{
+		APIKey: apiKey,
+		HTTPClient: &http.Client{
+			Timeout: time.Duration(5) * time.Second,
+		},
+	}
+}
+
+// TravelTimes requests durations in one batch call
+func (g *GoogleDistanceMatrix) TravelTimes(origin LatLng, destinations []LatLng, mode TravelMode) ([]TravelTime, error) {
+	if len(destinations) == 0 {
+		return []TravelTime{}, nil
+	}
+	if len(destinations) > MaxDestinations {
+		return nil, fmt.Errorf("too many destinations: %d", len(destinations))
+	}
+
+	dests := make([]string, len(destinations))
+	for i, d := range destinations {
+		dests[i] = d.String()
+	}
+	params := url.Values{}
+	params.Set("key", g.APIKey)
+	params.Set("language", "ja")
+	params.Set("origins", origin.String())
+	params.Set("destinations", strings.Join(dests, "|"))
+	params.Set("mode", string(mode))
+	uri := "https://maps.googleapis.com/maps/api/distancematrix/json?" + params.Encode()
+
+	resp, err := g.HTTPClient.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var matrix DistanceMatrix
+	if err := json.Unmarshal(body, &matrix); err != nil {
+		return nil, err
+	}
+	return matrix.TravelTimes(mode, len(destinations))
+}
+
+// TravelTimes converts the first row of DistanceMatrix
+func (m *DistanceMatrix) TravelTimes(mode TravelMode, n int) ([]TravelTime, error) {
+	if m.Status != "OK" {
+		return nil, errors.New("distance matrix: " + m.Status + " " + m.ErrorMessage)
+	}
+	if len(m.Rows) == 0 || len(m.Rows[0].Elements) != n {
+		return nil, errors.New("distance matrix: unexpected number of elements")
+	}
+	times := make([]TravelTime, n)
+	for i, e := range m.Rows[0].Elements {
+		// 経路が見つからない場合は空のまま
+		if e.Status != "OK" {
+			continue
+		}
+		times[i] = TravelTime{
+			Mode:     mode,
+			Duration: time.Duration(e.Duration.Value) * time.Second,
+			Text:     e.Duration.Text,
+		}
+	}
+	return times, nil
+}
@@ -0,0 +1,90 @@
+package places
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrNoFindPlaceResult errors
+var ErrNoFindPlaceResult = errors.New("findplace: zero results")
+
+// PlaceFinder resolves a free text (name and address) to a place ID
+type PlaceFinder interface {
+	FindPlace(input string, bias *LatLng) (string, error)
+}
+
+// FindPlaceResponse is a response of find place from text
+type FindPlaceResponse struct {
+	Candidates []struct {
+		PlaceID string `json:"place_id"`
+		Name    string `json:"name"`
+	} `json:"candidates"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// GoogleFindPlace is PlaceFinder using Google Places API
+type GoogleFindPlace struct {
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// NewGoogleFindPlace returns GoogleFindPlace
+func NewGoogleFindPlace(apiKey string) *GoogleFindPlace {
+	return &GoogleFindPlace{
+		APIKey: apiKey,
+		HTTPClient: &http.Client{
+			Timeout: time.Duration(5) * time.Second,
+		},
+	}
+}
+
+// FindPlace requests the place ID of the first candidate.
+// bias is a location near the place, if known
+func (g *GoogleFindPlace) FindPlace(input string, bias *LatLng) (string, error) {
+	params := url.Values{}
+	params.Set("key", g.APIKey)
+	params.Set("language", "ja")
+	params.Set("input", input)
+	params.Set("inputtype", "textquery")
+	params.Set("fields", "place_id,name")
+	if bias != nil {
+		params.Set("locationbias", "point:"+bias.String())
+	}
+	uri := "https://maps.googleapis.com/maps/api/place/findplacefromtext/json?" + params.Encode()
+
+	resp, err := g.HTTPClient.Get(uri)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var found FindPlaceResponse
+	if err := json.Unmarshal(body, &found); err != nil {
+		return "", err
+	}
+	return found.PlaceID()
+}
+
+// PlaceID returns the place ID of the first candidate
+func (f *FindPlaceResponse) PlaceID() (string, error) {
+	switch f.Status {
+	case "OK":
+	case "ZERO_RESULTS":
+		return "", ErrNoFindPlaceResult
+	default:
+		return "", errors.New("findplace: " + f.Status + " " + f.ErrorMessage)
+	}
+	if len(f.Candidates) == 0 {
+		return "", ErrNoFindPlaceResult
+	}
+	return f.Candidates[0].PlaceID, nil
+}
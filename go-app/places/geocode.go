@@ -0,0 +1,99 @@
+package places
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrNoGeocodeResult errors
+var ErrNoGeocodeResult = errors.New("geocode: zero results")
+
+// GeocodeResult is a resolved location
+type GeocodeResult struct {
+	Address  string
+	Location LatLng
+}
+
+// Geocoder converts a place name or address to a location
+type Geocoder interface {
+	Geocode(address string) (*GeocodeResult, error)
+}
+
+// Geocoding is a response of geocoding
+type Geocoding struct {
+	Results []struct {
+		FormattedAddress string `json:"formatted_address"`
+		Geometry         struct {
+			Location LatLng `json:"location"`
+		} `json:"geometry"`
+		PlaceID string   `json:"place_id"`
+		Types   []string `json:"types"`
+	} `json:"results"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// GoogleGeocoder is Geocoder using Google Geocoding API
+type GoogleGeocoder struct {
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// NewGoogleGeocoder returns GoogleGeocoder
+func NewGoogleGeocoder(apiKey string) *GoogleGeocoder {
+	return &GoogleGeocoder{
+		APIKey: apiKey,
+		HTTPClient: &http.Client{
+			Timeout: time.Duration(5) * time.Second,
+		},
+	}
+}
+
+// Geocode requests the first matching location in Japan
+func (g *GoogleGeocoder) Geocode(address string) (*GeocodeResult, error) {
+	params := url.Values{}
+	params.Set("key", g.APIKey)
+	params.Set("language", "ja")
+	params.Set("region", "jp")
+	params.Set("address", address)
+	uri := "https://maps.googleapis.com/maps/api/geocode/json?" + params.Encode()
+
+	resp, err := g.HTTPClient.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var geocoding Geocoding
+	if err := json.Unmarshal(body, &geocoding); err != nil {
+		return nil, err
+	}
+	return geocoding.Result()
+}
+
+// Result converts the first result of Geocoding
+func (g *Geocoding) Result() (*GeocodeResult, error) {
+	switch g.Status {
+	case "OK":
+	case "ZERO_RESULTS":
+		return nil, ErrNoGeocodeResult
+	default:
+		return nil, errors.New("geocode: " + g.Status + " " + g.ErrorMessage)
+	}
+	if len(g.Results) == 0 {
+		return nil, ErrNoGeocodeResult
+	}
+	r := g.Results[0]
+	return &GeocodeResult{
+		Address:  r.FormattedAddress,
+		Location: r.Geometry.Location,
+	}, nil
+}
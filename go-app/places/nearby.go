@@ -8,6 +8,9 @@ import (
 	"time"
 )
 
+// Nearby Searchで指定できる最大の半径(m)
+const MaxRadius int = 50000
+
 // NearbyPlaces is a response of nearby-search
 type NearbyPlaces struct {
 	HTMLAttributions []interface{} `json:"html_attributions"`
@@ -17,7 +20,8 @@ type NearbyPlaces struct {
 
 // NearbyPlace is a part of format of API response
 type NearbyPlace struct {
-	Geometry struct {
+	BusinessStatus string `json:"business_status"`
+	Geometry       struct {
 		Location LatLng `json:"location"`
 		Viewport struct {
 			Northeast LatLng `json:"northeast"`
@@ -57,11 +61,17 @@ func (p *NearbyPlace) MarshalPlace(gcpPlacesAPIKey string) Place {
 		"maxwidth": "350",
 	}
 	return Place{
-		PlaceID:      p.PlaceID,
-		Name:         p.Name,
-		Rating:       p.Rating,
-		PhotoURI:     p.PhotoURI(params),
-		GooglemapURI: p.GooglemapURI(),
+		PlaceID:        p.PlaceID,
+		Name:           p.Name,
+		Rating:         p.Rating,
+		PhotoURI:       p.PhotoURI(params),
+		GooglemapURI:   p.GooglemapURI(),
+		Lat:            p.Geometry.Location.Lat,
+		Lng:            p.Geometry.Location.Lng,
+		Address:        p.Vicinity,
+		BusinessStatus: p.BusinessStatus,
+		Types:          p.Types,
+		PriceLevel:     p.PriceLevel,
 	}
 }
 
@@ -128,8 +138,13 @@ func GooglemapPhotoURI(params map[string]string) string {
 
 // GooglemapURI returns uri of the place on googlemap
 func (p *NearbyPlace) GooglemapURI() string {
+	return GooglemapSearchURI(p.Geometry.Location, p.PlaceID)
+}
+
+// GooglemapSearchURI returns uri of the place on googlemap
+func GooglemapSearchURI(loc LatLng, placeID string) string {
 	uri := "https://www.google.com/maps/search/?api=1"
-	uri += "&query=" + p.Geometry.Location.Lat + "," + p.Geometry.Location.Lng
-	uri += "&query_place_id=" + p.PlaceID
+	uri += "&query=" + loc.Lat + "," + loc.Lng
+	uri += "&query_place_id=" + placeID
 	return uri
 }
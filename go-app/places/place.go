@@ -2,7 +2,9 @@ package places
 
 import (
 	"encoding/json"
+	"sort"
 	"strconv"
+	"time"
 )
 
 // Place is main data struct
@@ -12,11 +14,53 @@ type Place struct {
 	Rating       float64 `json:"rating" datastore:"rating,noindex"`
 	PhotoURI     string  `json:"photo_uri" datastore:"photo_uri,noindex"`
 	GooglemapURI string  `json:"googlemap_uri" datastore:"googlemap_uri,noindex"`
+	Lat          string  `json:"lat" datastore:"lat,noindex"`
+	Lng          string  `json:"lng" datastore:"lng,noindex"`
+	Address      string  `json:"address" datastore:"address,noindex"`
+	// 営業状況．古い保存データでは空
+	BusinessStatus string `json:"business_status" datastore:"business_status,noindex"`
+	// おすすめに使う種類と価格帯(0は不明)
+	Types      []string `json:"types" datastore:"types,noindex"`
+	PriceLevel int      `json:"price_level" datastore:"price_level,noindex"`
+	// 検索時のみ使う移動時間と最後に行った日時(保存しない)
+	TravelTime TravelTime `json:"-" datastore:"-"`
+	VisitedAt  time.Time  `json:"-" datastore:"-"`
+	// おすすめの理由(保存しない)
+	Reason string `json:"-" datastore:"-"`
+}
+
+// Business status of a place
+const (
+	BusinessStatusOperational       = "OPERATIONAL"
+	BusinessStatusClosedTemporarily = "CLOSED_TEMPORARILY"
+	BusinessStatusClosedPermanently = "CLOSED_PERMANENTLY"
+)
+
+// Closed returns whether the place has closed permanently
+func (p *Place) Closed() bool {
+	return p.BusinessStatus == BusinessStatusClosedPermanently
 }
 
 // Places is Place slice
 type Places []Place
 
+// LatLng returns location of the place
+func (p *Place) LatLng() LatLng {
+	return LatLng{Lat: p.Lat, Lng: p.Lng}
+}
+
+// SortByTravelTime sorts places in ascending order of travel time.
+// Places without travel time are moved to the end.
+func (p Places) SortByTravelTime() {
+	sort.SliceStable(p, func(i, j int) bool {
+		ti, tj := p[i].TravelTime, p[j].TravelTime
+		if !ti.Valid() || !tj.Valid() {
+			return ti.Valid() && !tj.Valid()
+		}
+		return ti.Duration < tj.Duration
+	})
+}
+
 // LatLng is used to unmarshal json
 type LatLng struct {
 	Lat string `json:"lat"`
@@ -36,3 +80,8 @@ func (ll *LatLng) UnmarshalJSON(b []byte) error {
 	ll.Lng = strconv.FormatFloat(a.Lng, 'f', -1, 64)
 	return nil
 }
+
+// String returns "lat,lng"
+func (ll LatLng) String() string {
+	return ll.Lat + "," + ll.Lng
+}
@@ -0,0 +1,237 @@
+package recommend
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+const (
+	// 好みとして覚えておく項目の最大数(種類ごと)
+	MaxCounts int = 30
+	// これより学習が少なければ並べ替えない
+	MinSignals int = 3
+	// 理由をつけるお店の数
+	MaxRecommended int = 3
+)
+
+// スコアの重み
+const (
+	keywordWeight = 1.0
+	typeWeight    = 0.6
+	priceWeight   = 0.4
+)
+
+// 飲食店ならほとんどについている種類なので好みに使わない
+var genericTypes = map[string]bool{
+	"restaurant":        true,
+	"food":              true,
+	"point_of_interest": true,
+	"establishment":     true,
+	"store":             true,
+}
+
+// 種類の表示名
+var typeLabels = map[string]string{
+	"cafe":          "カフェ",
+	"bar":           "バー",
+	"bakery":        "パン屋",
+	"meal_takeaway": "テイクアウト",
+	"meal_delivery": "デリバリー",
+	"night_club":    "ナイトクラブ",
+	"lodging":       "ホテル",
+	"shopping_mall": "ショッピングモール",
+}
+
+// 項目ごとの回数
+type Count struct {
+	Key string `datastore:"key,noindex"`
+	N   int    `datastore:"n,noindex"`
+}
+
+// ユーザの好み
+type Preference struct {
+	Keywords    []Count `datastore:"keywords,noindex"`
+	Types       []Count `datastore:"types,noindex"`
+	PriceLevels []Count `datastore:"price_levels,noindex"`
+}
+
+// 回数を足して，多い順に上限まで残す．
+// 同じ回数なら最近学習したものを前に置き，上限を超えたら回数が少なく古いものから捨てる
+// (新しい項目は残すので，上限に達した後も新しい好みを覚えられる)
+func add(counts []Count, key string, n int) []Count {
+	c := Count{Key: key}
+	rest := make([]Count, 0, len(counts)+1)
+	for _, e := range counts {
+		if e.Key == key {
+			c.N = e.N
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	c.N += n
+	counts = append([]Count{c}, rest...)
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].N > counts[j].N
+	})
+	if len(counts) > MaxCounts {
+		// 今回の項目以外で最後のもの
+		drop := len(counts) - 1
+		if counts[drop].Key == key {
+			drop--
+		}
+		counts = append(counts[:drop], counts[drop+1:]...)
+	}
+	return counts
+}
+
+func total(counts []Count) int {
+	sum := 0
+	for _, c := range counts {
+		sum += c.N
+	}
+	return sum
+}
+
+func find(counts []Count, key string) int {
+	for _, c := range counts {
+		if c.Key == key {
+			return c.N
+		}
+	}
+	return 0
+}
+
+// 検索したキーワードを覚える
+func (pref *Preference) LearnKeywords(keywords []string) {
+	for _, k := range keywords {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k != "" {
+			pref.Keywords = add(pref.Keywords, k, 1)
+		}
+	}
+}
+
+// お気に入りや行ったお店の種類と価格帯を覚える
+func (pref *Preference) LearnPlace(p *places.Place, weight int) {
+	for _, t := range p.Types {
+		if !genericTypes[t] {
+			pref.Types = add(pref.Types, t, weight)
+		}
+	}
+	if p.PriceLevel > 0 {
+		pref.PriceLevels = add(pref.PriceLevels, strconv.Itoa(p.PriceLevel), weight)
+	}
+}
+
+// 学習した回数
+func (pref *Preference) Signals() int {
+	return total(pref.Keywords) + total(pref.Types) + total(pref.PriceLevels)
+}
+
+// スコアの内訳
+type reason struct {
+	score float64
+	text  string
+}
+
+// Score returns how well the place matches the preference, with reasons in descending order
+func (pref *Preference) Score(p *places.Place) (float64, []string) {
+	reasons := []reason{}
+
+	if sum := total(pref.Keywords); sum > 0 {
+		name := strings.ToLower(p.Name)
+		best := Count{}
+		score := 0.0
+		for _, c := range pref.Keywords {
+			if strings.Contains(name, c.Key) {
+				score += float64(c.N) / float64(sum)
+				if c.N > best.N {
+					best = c
+				}
+			}
+		}
+		if score > 0 {
+			reasons = append(reasons, reason{score * keywordWeight, "よく検索する「" + best.Key + "」"})
+		}
+	}
+
+	if sum := total(pref.Types); sum > 0 {
+		best := Count{}
+		score := 0.0
+		for _, t := range p.Types {
+			if n := find(pref.Types, t); n > 0 {
+				score += float64(n) / float64(sum)
+				if n > best.N {
+					best = Count{Key: t, N: n}
+				}
+			}
+		}
+		if score > 0 {
+			reasons = append(reasons, reason{score * typeWeight, "お気に入りに多い" + typeLabel(best.Key)})
+		}
+	}
+
+	if sum := total(pref.PriceLevels); sum > 0 && p.PriceLevel > 0 {
+		if n := find(pref.PriceLevels, strconv.Itoa(p.PriceLevel)); n > 0 {
+			score := float64(n) / float64(sum)
+			reasons = append(reasons, reason{score * priceWeight, "いつもの価格帯(" + strings.Repeat("¥", p.PriceLevel) + ")"})
+		}
+	}
+
+	sort.SliceStable(reasons, func(i, j int) bool {
+		return reasons[i].score > reasons[j].score
+	})
+	score := 0.0
+	texts := make([]string, len(reasons))
+	for i, r := range reasons {
+		score += r.score
+		texts[i] = r.text
+	}
+	return score, texts
+}
+
+func typeLabel(t string) string {
+	if label, ok := typeLabels[t]; ok {
+		return "「" + label + "」"
+	}
+	return "ジャンル"
+}
+
+// Rank sorts places by the preference and sets Reason of the top places.
+// Places with the same score keep the original order
+func (pref *Preference) Rank(p places.Places) {
+	if pref.Signals() < MinSignals || len(p) == 0 {
+		return
+	}
+	type scored struct {
+		place   places.Place
+		score   float64
+		reasons []string
+	}
+	list := make([]scored, len(p))
+	for i := range p {
+		score, reasons := pref.Score(&p[i])
+		list[i] = scored{place: p[i], score: score, reasons: reasons}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].score > list[j].score
+	})
+	for i := range list {
+		p[i] = list[i].place
+		p[i].Reason = ""
+		if i < MaxRecommended && list[i].score > 0 {
+			p[i].Reason = Explain(list[i].reasons)
+		}
+	}
+}
+
+// Explain returns the explanation of the recommendation
+func Explain(reasons []string) string {
+	if len(reasons) > 2 {
+		reasons = reasons[:2]
+	}
+	return strings.Join(reasons, "・") + "に合うお店"
+}
@@ -0,0 +1,223 @@
+package recommend
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/Fukkatsuso/linebot-restaurant-go/go-app/places"
+)
+
+func TestLearnKeywords(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords []string
+		want     []Count
+	}{
+		{"normalize", []string{" Ramen ", "ラーメン", "", "ramen"}, []Count{{"ramen", 2}, {"ラーメン", 1}}},
+		// 同じ回数なら最近のものが前
+		{"tie", []string{"b", "a"}, []Count{{"a", 1}, {"b", 1}}},
+		{"recent", []string{"a", "b"}, []Count{{"b", 1}, {"a", 1}}},
+	}
+	for _, tt := range tests {
+		pref := Preference{}
+		pref.LearnKeywords(tt.keywords)
+		if !reflect.DeepEqual(pref.Keywords, tt.want) {
+			t.Errorf("%s: Keywords = %v, want %v", tt.name, pref.Keywords, tt.want)
+		}
+	}
+}
+
+func TestLearnKeywordsLimit(t *testing.T) {
+	pref := Preference{}
+	pref.LearnKeywords([]string{"often", "often"})
+	for i := 0; i < MaxCounts; i++ {
+		pref.LearnKeywords([]string{fmt.Sprintf("k%02d", i)})
+	}
+	if len(pref.Keywords) != MaxCounts {
+		t.Fatalf("len(Keywords) = %d, want %d", len(pref.Keywords), MaxCounts)
+	}
+	// 多いものは残り，同じ回数なら古いものから消える
+	if pref.Keywords[0] != (Count{"often", 2}) {
+		t.Errorf("Keywords[0] = %v, want often", pref.Keywords[0])
+	}
+	if n := find(pref.Keywords, "k00"); n != 0 {
+		t.Errorf("oldest keyword is kept with %d", n)
+	}
+	if n := find(pref.Keywords, fmt.Sprintf("k%02d", MaxCounts-1)); n != 1 {
+		t.Errorf("last keyword is learned %d times, want 1", n)
+	}
+
+	// 上限に達した後も新しいキーワードを覚える
+	pref.LearnKeywords([]string{"ラーメン"})
+	if n := find(pref.Keywords, "ラーメン"); n != 1 {
+		t.Errorf("new keyword is learned %d times, want 1", n)
+	}
+	if n := find(pref.Keywords, "k01"); n != 0 {
+		t.Errorf("oldest keyword is kept with %d", n)
+	}
+	if len(pref.Keywords) != MaxCounts {
+		t.Errorf("len(Keywords) = %d, want %d", len(pref.Keywords), MaxCounts)
+	}
+}
+
+func TestLearnKeywordsLimitKeepsNewKey(t *testing.T) {
+	pref := Preference{}
+	for i := 0; i < MaxCounts; i++ {
+		k := fmt.Sprintf("k%02d", i)
+		pref.LearnKeywords([]string{k, k})
+	}
+	// ほかより少なくても新しいキーワードは残し，少なく古いものを消す
+	pref.LearnKeywords([]string{"ラーメン"})
+	if n := find(pref.Keywords, "ラーメン"); n != 1 {
+		t.Errorf("new keyword is learned %d times, want 1", n)
+	}
+	if n := find(pref.Keywords, "k00"); n != 0 {
+		t.Errorf("oldest keyword is kept with %d", n)
+	}
+}
+
+func TestLearnPlace(t *testing.T) {
+	pref := Preference{}
+	pref.LearnPlace(&places.Place{Types: []string{"cafe", "restaurant", "food", "bakery"}, PriceLevel: 2}, 2)
+	pref.LearnPlace(&places.Place{Types: []string{"cafe", "establishment"}}, 1)
+
+	wantTypes := []Count{{"cafe", 3}, {"bakery", 2}}
+	if !reflect.DeepEqual(pref.Types, wantTypes) {
+		t.Errorf("Types = %v, want %v", pref.Types, wantTypes)
+	}
+	// 価格帯のないお店は価格帯を覚えない
+	wantPrices := []Count{{"2", 2}}
+	if !reflect.DeepEqual(pref.PriceLevels, wantPrices) {
+		t.Errorf("PriceLevels = %v, want %v", pref.PriceLevels, wantPrices)
+	}
+	if got := pref.Signals(); got != 7 {
+		t.Errorf("Signals() = %d, want 7", got)
+	}
+}
+
+func TestScore(t *testing.T) {
+	pref := Preference{
+		Keywords:    []Count{{"ラーメン", 3}, {"cafe", 1}},
+		Types:       []Count{{"cafe", 2}, {"chinese_restaurant", 2}},
+		PriceLevels: []Count{{"1", 1}, {"2", 1}},
+	}
+	tests := []struct {
+		name    string
+		place   places.Place
+		score   float64
+		reasons []string
+	}{
+		{
+			"all",
+			places.Place{Name: "ラーメン CAFE", Types: []string{"cafe"}, PriceLevel: 1},
+			1.0 + 0.3 + 0.2,
+			[]string{"よく検索する「ラーメン」", "お気に入りに多い「カフェ」", "いつもの価格帯(¥)"},
+		},
+		{
+			"reasons by score",
+			places.Place{Name: "中華", Types: []string{"cafe", "chinese_restaurant"}, PriceLevel: 2},
+			0.6 + 0.2,
+			[]string{"お気に入りに多い「カフェ」", "いつもの価格帯(¥¥)"},
+		},
+		{
+			"type without label",
+			places.Place{Name: "中華", Types: []string{"chinese_restaurant"}},
+			0.3,
+			[]string{"お気に入りに多いジャンル"},
+		},
+		{
+			"no match",
+			places.Place{Name: "寿司", Types: []string{"restaurant"}, PriceLevel: 4},
+			0,
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		score, reasons := pref.Score(&tt.place)
+		if math.Abs(score-tt.score) > 1e-9 {
+			t.Errorf("%s: score = %v, want %v", tt.name, score, tt.score)
+		}
+		if !reflect.DeepEqual(reasons, tt.reasons) {
+			t.Errorf("%s: reasons = %q, want %q", tt.name, reasons, tt.reasons)
+		}
+	}
+}
+
+func names(p places.Places) []string {
+	names := make([]string, len(p))
+	for i := range p {
+		names[i] = p[i].Name
+	}
+	return names
+}
+
+func reasons(p places.Places) []string {
+	reasons := make([]string, len(p))
+	for i := range p {
+		reasons[i] = p[i].Reason
+	}
+	return reasons
+}
+
+func TestRank(t *testing.T) {
+	pref := Preference{Keywords: []Count{{"ラーメン", 3}}}
+	p := places.Places{
+		{Name: "カレー"},
+		{Name: "ラーメン一番"},
+		{Name: "寿司", Reason: "前回の理由"},
+		{Name: "ラーメン二番"},
+	}
+	pref.Rank(p)
+	// 同じスコアなら元の順番
+	wantNames := []string{"ラーメン一番", "ラーメン二番", "カレー", "寿司"}
+	if got := names(p); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("order = %v, want %v", got, wantNames)
+	}
+	reason := "よく検索する「ラーメン」に合うお店"
+	wantReasons := []string{reason, reason, "", ""}
+	if got := reasons(p); !reflect.DeepEqual(got, wantReasons) {
+		t.Errorf("reasons = %q, want %q", got, wantReasons)
+	}
+}
+
+func TestRankTopOnly(t *testing.T) {
+	pref := Preference{Keywords: []Count{{"店", 3}}}
+	p := places.Places{{Name: "店1"}, {Name: "店2"}, {Name: "店3"}, {Name: "店4"}}
+	pref.Rank(p)
+	got := reasons(p)
+	for i := range got {
+		if want := i < MaxRecommended; (got[i] != "") != want {
+			t.Errorf("reason of %s = %q, want reason: %v", p[i].Name, got[i], want)
+		}
+	}
+}
+
+func TestRankFewSignals(t *testing.T) {
+	pref := Preference{Keywords: []Count{{"ラーメン", MinSignals - 1}}}
+	p := places.Places{{Name: "カレー"}, {Name: "ラーメン"}}
+	pref.Rank(p)
+	if got := names(p); !reflect.DeepEqual(got, []string{"カレー", "ラーメン"}) {
+		t.Errorf("order = %v, want unchanged", got)
+	}
+	if got := reasons(p); !reflect.DeepEqual(got, []string{"", ""}) {
+		t.Errorf("reasons = %q, want none", got)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		reasons []string
+		want    string
+	}{
+		{[]string{"A"}, "Aに合うお店"},
+		{[]string{"A", "B"}, "A・Bに合うお店"},
+		{[]string{"A", "B", "C"}, "A・Bに合うお店"},
+	}
+	for _, tt := range tests {
+		if got := Explain(tt.reasons); got != tt.want {
+			t.Errorf("Explain(%q) = %q, want %q", tt.reasons, got, tt.want)
+		}
+	}
+}
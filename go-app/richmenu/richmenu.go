@@ -0,0 +1,151 @@
+package richmenu
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// このボットが作ったメニューの名前の接頭辞．
+// 名前は「接頭辞:種類:定義のハッシュ」にして，定義が変わったら作り直す
+const NamePrefix = "restaurant-search"
+
+// メニューの種類
+const (
+	// 普段のメニュー(全ユーザのデフォルト)
+	Default = "default"
+	// 検索条件を設定している間のメニュー
+	Searching = "searching"
+)
+
+// メニューの幅と1段の高さ(px)
+const (
+	width     = 2500
+	rowHeight = 843
+)
+
+// メニューのボタン．タップすると Text を送信する
+type Button struct {
+	Text  string
+	Color color.RGBA
+}
+
+// Menu is a rich menu definition
+type Menu struct {
+	Kind        string
+	ChatBarText string
+	// 段ごとのボタン
+	Rows [][]Button
+}
+
+var (
+	orange = color.RGBA{0xf3, 0x98, 0x00, 0xff}
+	green  = color.RGBA{0x2e, 0xa4, 0x4f, 0xff}
+	blue   = color.RGBA{0x2f, 0x80, 0xed, 0xff}
+	gray   = color.RGBA{0x8a, 0x8a, 0x8a, 0xff}
+)
+
+// Menus are the rich menus of this bot
+var Menus = []Menu{
+	{
+		Kind:        Default,
+		ChatBarText: "メニュー",
+		Rows: [][]Button{
+			{{"位置情報検索", orange}, {"前回の条件で検索", orange}, {"登録地点", orange}},
+			{{"お気に入りを見る", green}, {"検索履歴", blue}, {"履歴", blue}},
+		},
+	},
+	{
+		Kind:        Searching,
+		ChatBarText: "検索中",
+		Rows: [][]Button{
+			{{"戻る", orange}, {"位置情報検索", orange}, {"お気に入りを見る", green}, {"キャンセル", gray}},
+		},
+	},
+}
+
+func (m *Menu) height() int {
+	return rowHeight * len(m.Rows)
+}
+
+// 各ボタンの範囲
+func (m *Menu) areas() []linebot.AreaDetail {
+	areas := []linebot.AreaDetail{}
+	for r, row := range m.Rows {
+		for c, b := range row {
+			x0, x1 := width*c/len(row), width*(c+1)/len(row)
+			areas = append(areas, linebot.AreaDetail{
+				Bounds: linebot.RichMenuBounds{X: x0, Y: rowHeight * r, Width: x1 - x0, Height: rowHeight},
+				Action: linebot.RichMenuAction{Type: linebot.RichMenuActionTypeMessage, Text: b.Text},
+			})
+		}
+	}
+	return areas
+}
+
+// RichMenu returns the request body of the Messaging API.
+// 画像が変わっても作り直すように画像のハッシュも名前に含める
+func (m *Menu) RichMenu(img []byte) linebot.RichMenu {
+	menu := linebot.RichMenu{
+		Size:        linebot.RichMenuSize{Width: width, Height: m.height()},
+		Selected:    true,
+		ChatBarText: m.ChatBarText,
+		Areas:       m.areas(),
+	}
+	b, _ := json.Marshal(menu)
+	h := sha256.New()
+	h.Write(b)
+	h.Write(img)
+	menu.Name = namePrefix(m.Kind) + hex.EncodeToString(h.Sum(nil))[:12]
+	return menu
+}
+
+func namePrefix(kind string) string {
+	return NamePrefix + ":" + kind + ":"
+}
+
+// メニュー名から種類を取り出す．このボットのメニューでなければfalse
+func kindOf(name string) (string, bool) {
+	parts := strings.Split(name, ":")
+	if len(parts) != 3 || parts[0] != NamePrefix {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// 画像ファイルがないときの代わりの画像．ボタンごとに色分けしただけのもの
+func (m *Menu) PlaceholderImage() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, m.height()))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	// ボタンの間に余白を空ける
+	const margin = 8
+	for _, a := range m.areas() {
+		b := a.Bounds
+		rect := image.Rect(b.X+margin, b.Y+margin, b.X+b.Width-margin, b.Y+b.Height-margin)
+		draw.Draw(img, rect, &image.Uniform{m.button(a.Action.Text).Color}, image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Menu) button(text string) Button {
+	for _, row := range m.Rows {
+		for _, b := range row {
+			if b.Text == text {
+				return b
+			}
+		}
+	}
+	return Button{Color: gray}
+}
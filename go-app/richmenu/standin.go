@@ -0,0 +1,170 @@
+package richmenu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// StandInServer is an in-memory stand-in for the rich menu endpoints of the Messaging API.
+// LINE_API_ENDPOINT をこのサーバに向けると，本物のチャネルを使わずに作成や切り替えを確かめられる
+type StandInServer struct {
+	mu        sync.Mutex
+	nextID    int
+	menus     map[string]*linebot.RichMenuResponse
+	images    map[string][]byte
+	defaultID string
+	// ユーザIDとリンクしたメニューID
+	links map[string]string
+}
+
+func NewStandInServer() *StandInServer {
+	return &StandInServer{
+		menus:  map[string]*linebot.RichMenuResponse{},
+		images: map[string][]byte{},
+		links:  map[string]string{},
+	}
+}
+
+func (s *StandInServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log.Println("[StandIn]", r.Method, r.URL.Path)
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/bot/"), "/")
+	switch {
+	// /v2/bot/richmenu
+	case len(path) == 1 && path[0] == "richmenu" && r.Method == http.MethodPost:
+		s.create(w, r)
+	// /v2/bot/richmenu/list
+	case len(path) == 2 && path[0] == "richmenu" && path[1] == "list":
+		list := []*linebot.RichMenuResponse{}
+		for _, m := range s.menus {
+			list = append(list, m)
+		}
+		writeJSON(w, map[string]interface{}{"richmenus": list})
+	// /v2/bot/richmenu/{id}
+	case len(path) == 2 && path[0] == "richmenu":
+		m, ok := s.menus[path[1]]
+		if !ok {
+			notFound(w, "rich menu not found")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			s.delete(m.RichMenuID)
+			writeJSON(w, struct{}{})
+			return
+		}
+		writeJSON(w, m)
+	// /v2/bot/richmenu/{id}/content
+	case len(path) == 3 && path[0] == "richmenu" && path[2] == "content":
+		if _, ok := s.menus[path[1]]; !ok {
+			notFound(w, "rich menu not found")
+			return
+		}
+		if r.Method == http.MethodPost {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.images[path[1]] = body
+			writeJSON(w, struct{}{})
+			return
+		}
+		img, ok := s.images[path[1]]
+		if !ok {
+			notFound(w, "image not found")
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(img))
+		w.Write(img)
+	// /v2/bot/user/{userId|all}/richmenu[/{id}]
+	case (len(path) == 3 || len(path) == 4) && path[0] == "user" && path[2] == "richmenu":
+		s.user(w, r, path[1], path[3:])
+	default:
+		notFound(w, "not supported by the stand-in server")
+	}
+}
+
+func (s *StandInServer) create(w http.ResponseWriter, r *http.Request) {
+	m := linebot.RichMenuResponse{}
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.nextID++
+	m.RichMenuID = fmt.Sprintf("richmenu-standin-%d", s.nextID)
+	s.menus[m.RichMenuID] = &m
+	writeJSON(w, linebot.RichMenuIDResponse{RichMenuID: m.RichMenuID})
+}
+
+func (s *StandInServer) delete(id string) {
+	delete(s.menus, id)
+	delete(s.images, id)
+	if s.defaultID == id {
+		s.defaultID = ""
+	}
+	for user, linked := range s.links {
+		if linked == id {
+			delete(s.links, user)
+		}
+	}
+}
+
+// デフォルトのメニュー(user=all)とユーザごとのメニュー
+func (s *StandInServer) user(w http.ResponseWriter, r *http.Request, user string, rest []string) {
+	if r.Method == http.MethodPost {
+		if len(rest) != 1 {
+			notFound(w, "rich menu id is required")
+			return
+		}
+		if _, ok := s.menus[rest[0]]; !ok {
+			notFound(w, "rich menu not found")
+			return
+		}
+		if user == "all" {
+			s.defaultID = rest[0]
+		} else {
+			s.links[user] = rest[0]
+		}
+		writeJSON(w, struct{}{})
+		return
+	}
+
+	id := s.links[user]
+	if user == "all" {
+		id = s.defaultID
+	}
+	if id == "" {
+		notFound(w, "no rich menu")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if user == "all" {
+			s.defaultID = ""
+		} else {
+			delete(s.links, user)
+		}
+		writeJSON(w, struct{}{})
+		return
+	}
+	writeJSON(w, linebot.RichMenuIDResponse{RichMenuID: id})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,147 @@
+package richmenu
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// 画像ファイルの拡張子
+var imageExts = []string{".png", ".jpg", ".jpeg"}
+
+// アップロードする画像のパスと中身．
+// imageDir/<種類>.png がなければ代わりの画像を一時ファイルに書き出す(cleanupで消す)
+func (m *Menu) image(imageDir string) (path string, img []byte, cleanup func(), err error) {
+	cleanup = func() {}
+	if imageDir != "" {
+		for _, ext := range imageExts {
+			path = filepath.Join(imageDir, m.Kind+ext)
+			if img, err = ioutil.ReadFile(path); err == nil {
+				return path, img, cleanup, nil
+			}
+		}
+	}
+	img, err = m.PlaceholderImage()
+	if err != nil {
+		return "", nil, cleanup, err
+	}
+	f, err := ioutil.TempFile("", "richmenu-*.png")
+	if err != nil {
+		return "", nil, cleanup, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	if _, err := f.Write(img); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, func() {}, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, func() {}, err
+	}
+	return f.Name(), img, cleanup, nil
+}
+
+// Sync creates the rich menus whose definitions or images have changed,
+// sets the default menu and deletes old menus of this bot.
+// 種類ごとのメニューIDを返す
+func Sync(ctx context.Context, client *linebot.Client, imageDir string) (map[string]string, error) {
+	existing, err := client.GetRichMenuList().WithContext(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]string{}
+	for _, m := range existing {
+		byName[m.Name] = m.RichMenuID
+	}
+
+	ids := map[string]string{}
+	for i := range Menus {
+		id, err := create(ctx, client, &Menus[i], imageDir, byName)
+		if err != nil {
+			return nil, err
+		}
+		ids[Menus[i].Kind] = id
+	}
+
+	if id, ok := ids[Default]; ok {
+		if _, err := client.SetDefaultRichMenu(id).WithContext(ctx).Do(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 使わなくなったメニューを消す．リンクしていたユーザはデフォルトのメニューに戻る
+	current := map[string]bool{}
+	for _, id := range ids {
+		current[id] = true
+	}
+	for _, m := range existing {
+		if _, ok := kindOf(m.Name); ok && !current[m.RichMenuID] {
+			if _, err := client.DeleteRichMenu(m.RichMenuID).WithContext(ctx).Do(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ids, nil
+}
+
+// 同じ定義のメニューがなければ作って画像をアップロードする
+func create(ctx context.Context, client *linebot.Client, m *Menu, imageDir string, byName map[string]string) (string, error) {
+	path, img, cleanup, err := m.image(imageDir)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	menu := m.RichMenu(img)
+	if id, ok := byName[menu.Name]; ok {
+		return id, nil
+	}
+	res, err := client.CreateRichMenu(menu).WithContext(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	if _, err := client.UploadRichMenuImage(res.RichMenuID, path).WithContext(ctx).Do(); err != nil {
+		// 画像のないメニューが残ると次回も使われてしまうので消す
+		client.DeleteRichMenu(res.RichMenuID).WithContext(ctx).Do()
+		return "", err
+	}
+	return res.RichMenuID, nil
+}
+
+// Lookup returns the IDs of the rich menus created by Sync
+func Lookup(ctx context.Context, client *linebot.Client) (map[string]string, error) {
+	existing, err := client.GetRichMenuList().WithContext(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]string{}
+	for _, m := range existing {
+		if kind, ok := kindOf(m.Name); ok {
+			ids[kind] = m.RichMenuID
+		}
+	}
+	return ids, nil
+}
+
+// Delete deletes all rich menus of this bot
+func Delete(ctx context.Context, client *linebot.Client) (int, error) {
+	existing, err := client.GetRichMenuList().WithContext(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range existing {
+		if _, ok := kindOf(m.Name); !ok {
+			continue
+		}
+		if _, err := client.DeleteRichMenu(m.RichMenuID).WithContext(ctx).Do(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,182 @@
+package richmenu
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func newStandInClient(t *testing.T) (*linebot.Client, *StandInServer) {
+	t.Helper()
+	standIn := NewStandInServer()
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	client, err := linebot.New("secret", "token",
+		linebot.WithEndpointBase(server.URL),
+		linebot.WithEndpointBaseData(server.URL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, standIn
+}
+
+// 代役サーバにあるメニューの名前
+func menuNames(t *testing.T, client *linebot.Client) []string {
+	t.Helper()
+	list, err := client.GetRichMenuList().Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, m := range list {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func defaultMenuID(t *testing.T, client *linebot.Client) string {
+	t.Helper()
+	res, err := client.GetDefaultRichMenu().Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.RichMenuID
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	client, standIn := newStandInClient(t)
+
+	ids, err := Sync(ctx, client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(Menus) || ids[Default] == "" || ids[Searching] == "" {
+		t.Fatalf("Sync() = %v, want ids of %d menus", ids, len(Menus))
+	}
+	if got := defaultMenuID(t, client); got != ids[Default] {
+		t.Errorf("default menu = %q, want %q", got, ids[Default])
+	}
+	for _, id := range ids {
+		if len(standIn.images[id]) == 0 {
+			t.Errorf("image of %q is not uploaded", id)
+		}
+	}
+	names := menuNames(t, client)
+
+	t.Run("idempotent", func(t *testing.T) {
+		again, err := Sync(ctx, client, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(again, ids) {
+			t.Errorf("second Sync() = %v, want %v", again, ids)
+		}
+		if got := menuNames(t, client); !reflect.DeepEqual(got, names) {
+			t.Errorf("menus after second Sync() = %v, want %v", got, names)
+		}
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		got, err := Lookup(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, ids) {
+			t.Errorf("Lookup() = %v, want %v", got, ids)
+		}
+	})
+
+	t.Run("delete old menus", func(t *testing.T) {
+		menu := Menus[0].RichMenu(nil)
+		menu.Name = namePrefix(Default) + "000000000000"
+		old, err := client.CreateRichMenu(menu).Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		menu.Name = "another-bot"
+		other, err := client.CreateRichMenu(menu).Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.LinkUserRichMenu("U1", old.RichMenuID).Do(); err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := Sync(ctx, client, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(again, ids) {
+			t.Errorf("Sync() = %v, want %v", again, ids)
+		}
+		if _, ok := standIn.menus[old.RichMenuID]; ok {
+			t.Errorf("old menu %q is not deleted", old.RichMenuID)
+		}
+		if _, ok := standIn.links["U1"]; ok {
+			t.Error("user is still linked to the deleted menu")
+		}
+		// ほかのボットのメニューは消さない
+		if _, ok := standIn.menus[other.RichMenuID]; !ok {
+			t.Errorf("menu of another bot %q is deleted", other.RichMenuID)
+		}
+	})
+
+	t.Run("recreate on image change", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "richmenu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		// 別のメニューの仮の画像を画像ファイルとして置く
+		img, err := Menus[0].PlaceholderImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, Searching+".png"), img, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		updated, err := Sync(ctx, client, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated[Default] != ids[Default] {
+			t.Errorf("default menu is recreated: %q -> %q", ids[Default], updated[Default])
+		}
+		if updated[Searching] == ids[Searching] {
+			t.Error("searching menu is not recreated")
+		}
+		if _, ok := standIn.menus[ids[Searching]]; ok {
+			t.Errorf("previous searching menu %q is not deleted", ids[Searching])
+		}
+	})
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		ok   bool
+	}{
+		{NamePrefix + ":default:0123456789ab", Default, true},
+		{NamePrefix + ":searching:0123456789ab", Searching, true},
+		{"another-bot", "", false},
+		{"another-bot:default:0123456789ab", "", false},
+	}
+	for _, tt := range tests {
+		kind, ok := kindOf(tt.name)
+		if kind != tt.kind || ok != tt.ok {
+			t.Errorf("kindOf(%q) = %q, %v, want %q, %v", tt.name, kind, ok, tt.kind, tt.ok)
+		}
+	}
+}
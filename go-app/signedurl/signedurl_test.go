@@ -0,0 +1,34 @@
+package signedurl
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1600000000, 0)
+	signed := Sign(key, url.Values{"t": {"token"}}, now.Add(time.Minute))
+	tampered := Sign(key, url.Values{"t": {"token"}}, now.Add(time.Minute))
+	tampered.Set("t", "other")
+
+	tests := []struct {
+		name   string
+		key    []byte
+		params url.Values
+		now    time.Time
+		want   error
+	}{
+		{"valid", key, signed, now, nil},
+		{"expired", key, signed, now.Add(2 * time.Minute), ErrExpired},
+		{"tampered", key, tampered, now, ErrInvalidSignature},
+		{"wrong key", []byte("other"), signed, now, ErrInvalidSignature},
+		{"empty key", nil, Sign(nil, url.Values{"t": {"token"}}, now.Add(time.Minute)), now, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.key, tt.params, tt.now); got != tt.want {
+			t.Errorf("%s: Verify() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
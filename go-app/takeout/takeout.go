@@ -0,0 +1,236 @@
+package takeout
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 読み込んだお店1件分
+type Entry struct {
+	Name     string
+	Address  string
+	Lat      string
+	Lng      string
+	URL      string
+	PlaceID  string
+	Note     string
+	Tags     []string
+	MyRating int
+}
+
+// Errors
+var (
+	ErrUnknownFormat = errors.New("takeout: unknown file format")
+	ErrNoEntries     = errors.New("takeout: no entries")
+)
+
+// HasLocation returns whether the entry has valid coordinates
+func (e *Entry) HasLocation() bool {
+	lat, err1 := strconv.ParseFloat(e.Lat, 64)
+	lng, err2 := strconv.ParseFloat(e.Lng, 64)
+	// Takeoutでは座標不明のとき0,0になっている
+	return err1 == nil && err2 == nil && !(lat == 0 && lng == 0)
+}
+
+// Query returns a text to find the place
+func (e *Entry) Query() string {
+	return strings.TrimSpace(e.Name + " " + e.Address)
+}
+
+var bom = []byte("\ufeff")
+
+// Parse reads Google Takeout saved places (GeoJSON) or CSV
+func Parse(filename string, r io.Reader) ([]Entry, error) {
+	br := bufio.NewReader(r)
+	// Excelなどで保存したファイルの先頭のBOMはJSONとして読めないので読み飛ばす
+	if head, _ := br.Peek(len(bom)); bytes.Equal(head, bom) {
+		br.Discard(len(bom))
+	}
+	var entries []Entry
+	var err error
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".json", ".geojson":
+		entries, err = ParseGeoJSON(br)
+	case ".csv":
+		entries, err = ParseCSV(br)
+	default:
+		// 拡張子がわからなければ中身で判断する
+		head, _ := br.Peek(512)
+		head = bytes.TrimLeft(head, " \t\r\n")
+		if len(head) > 0 && head[0] == '{' {
+			entries, err = ParseGeoJSON(br)
+		} else if len(head) > 0 {
+			entries, err = ParseCSV(br)
+		} else {
+			return nil, ErrUnknownFormat
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoEntries
+	}
+	return entries, nil
+}
+
+type geoJSON struct {
+	Features []struct {
+		Geometry struct {
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			// Takeout(新形式)
+			GoogleMapsURL string `json:"google_maps_url"`
+			Location      struct {
+				Name    string `json:"name"`
+				Address string `json:"address"`
+			} `json:"location"`
+			// Takeout(旧形式)
+			Title       string `json:"Title"`
+			OldMapsURL  string `json:"Google Maps URL"`
+			OldLocation struct {
+				Address      string `json:"Address"`
+				BusinessName string `json:"Business Name"`
+			} `json:"Location"`
+			// このbotのエクスポート
+			Name     string   `json:"name"`
+			Address  string   `json:"address"`
+			URL      string   `json:"url"`
+			Tags     []string `json:"tags"`
+			Memo     string   `json:"memo"`
+			MyRating int      `json:"my_rating"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// ParseGeoJSON reads GeoJSON FeatureCollection
+func ParseGeoJSON(r io.Reader) ([]Entry, error) {
+	var g geoJSON
+	if err := json.NewDecoder(r).Decode(&g); err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, f := range g.Features {
+		p := f.Properties
+		e := Entry{
+			Name:     firstNonEmpty(p.Location.Name, p.OldLocation.BusinessName, p.Title, p.Name),
+			Address:  firstNonEmpty(p.Location.Address, p.OldLocation.Address, p.Address),
+			URL:      firstNonEmpty(p.GoogleMapsURL, p.OldMapsURL, p.URL),
+			Note:     p.Memo,
+			Tags:     p.Tags,
+			MyRating: p.MyRating,
+		}
+		if c := f.Geometry.Coordinates; len(c) >= 2 {
+			// GeoJSONは経度,緯度の順
+			e.Lng = strconv.FormatFloat(c[0], 'f', -1, 64)
+			e.Lat = strconv.FormatFloat(c[1], 'f', -1, 64)
+		}
+		e.PlaceID = PlaceIDFromURL(e.URL)
+		if e.Name == "" && e.Address == "" && e.PlaceID == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ParseCSV reads CSV with header row.
+// Takeoutの保存リスト(Title,Note,URL)とこのbotのエクスポートに対応
+func ParseCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		columns[h] = i
+	}
+	get := func(row []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(row) {
+				if v := strings.TrimSpace(row[i]); v != "" {
+					return v
+				}
+			}
+		}
+		return ""
+	}
+	if _, ok := columns["title"]; !ok {
+		if _, ok := columns["name"]; !ok {
+			return nil, ErrUnknownFormat
+		}
+	}
+
+	entries := []Entry{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{
+			Name:    get(row, "title", "name"),
+			Address: get(row, "address"),
+			Lat:     get(row, "lat", "latitude"),
+			Lng:     get(row, "lng", "longitude"),
+			URL:     get(row, "url"),
+			Note:    get(row, "note", "memo", "comment"),
+		}
+		if tags := get(row, "tags"); tags != "" {
+			e.Tags = strings.Fields(strings.Replace(tags, ",", " ", -1))
+		}
+		if rating, err := strconv.Atoi(get(row, "my_rating")); err == nil {
+			e.MyRating = rating
+		}
+		e.PlaceID = PlaceIDFromURL(e.URL)
+		if e.Name == "" && e.PlaceID == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// PlaceIDFromURL extracts place ID from Google Maps URL, if contained
+func PlaceIDFromURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	if id := q.Get("query_place_id"); id != "" {
+		return id
+	}
+	if id := q.Get("destination_place_id"); id != "" {
+		return id
+	}
+	// https://www.google.com/maps/place/?q=place_id:XXXX
+	if strings.HasPrefix(q.Get("q"), "place_id:") {
+		return strings.TrimPrefix(q.Get("q"), "place_id:")
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package takeout
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlaceIDFromURL(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"https://www.google.com/maps/search/?api=1&query=x&query_place_id=ChIJ1", "ChIJ1"},
+		{"https://www.google.com/maps/dir/?api=1&destination=x&destination_place_id=ChIJ2", "ChIJ2"},
+		{"https://www.google.com/maps/place/?q=place_id:ChIJ3", "ChIJ3"},
+		{"http://maps.google.com/?cid=1234567890", ""},
+		{"", ""},
+		{"%zz", ""},
+	}
+	for _, tt := range tests {
+		if got := PlaceIDFromURL(tt.uri); got != tt.want {
+			t.Errorf("PlaceIDFromURL(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestParseGeoJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Entry
+	}{
+		{
+			"takeout",
+			`{"type":"FeatureCollection","features":[{"geometry":{"coordinates":[139.767125,35.681236]},"properties":{"google_maps_url":"https://www.google.com/maps/place/?q=place_id:ChIJ1","location":{"name":"東京駅","address":"東京都千代田区丸の内1丁目"}}}]}`,
+			[]Entry{{Name: "東京駅", Address: "東京都千代田区丸の内1丁目", Lat: "35.681236", Lng: "139.767125", URL: "https://www.google.com/maps/place/?q=place_id:ChIJ1", PlaceID: "ChIJ1"}},
+		},
+		{
+			"old takeout",
+			`{"features":[{"geometry":{"coordinates":[139.7,35.6]},"properties":{"Title":"タイトル","Google Maps URL":"http://maps.google.com/?cid=1","Location":{"Address":"渋谷区","Business Name":"ラーメン屋"}}}]}`,
+			[]Entry{{Name: "ラーメン屋", Address: "渋谷区", Lat: "35.6", Lng: "139.7", URL: "http://maps.google.com/?cid=1"}},
+		},
+		{
+			"export",
+			`{"features":[{"geometry":null,"properties":{"name":"カレー屋","address":"新宿区","url":"https://www.google.com/maps/search/?api=1&query=x&query_place_id=ChIJ2","tags":["ランチ","辛い"],"memo":"大盛り","my_rating":4}}]}`,
+			[]Entry{{Name: "カレー屋", Address: "新宿区", URL: "https://www.google.com/maps/search/?api=1&query=x&query_place_id=ChIJ2", PlaceID: "ChIJ2", Note: "大盛り", Tags: []string{"ランチ", "辛い"}, MyRating: 4}},
+		},
+		{
+			// 場所を表すものがなければ飛ばす
+			"no place",
+			`{"features":[{"geometry":{"coordinates":[139.7,35.6]},"properties":{}},{"properties":{"name":"寿司屋"}}]}`,
+			[]Entry{{Name: "寿司屋"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseGeoJSON(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Entry
+	}{
+		{
+			"takeout",
+			"\ufeffTitle,Note,URL,Comment\n東京駅,待ち合わせ,https://www.google.com/maps/place/?q=place_id:ChIJ1,\nラーメン屋,,http://maps.google.com/?cid=1,行列\n",
+			[]Entry{
+				{Name: "東京駅", Note: "待ち合わせ", URL: "https://www.google.com/maps/place/?q=place_id:ChIJ1", PlaceID: "ChIJ1"},
+				{Name: "ラーメン屋", Note: "行列", URL: "http://maps.google.com/?cid=1"},
+			},
+		},
+		{
+			"export",
+			"\ufeffname,lat,lng,address,url,tags,memo,my_rating\nカレー屋,35.69,139.70,新宿区,,\"ランチ,辛い\",大盛り,4\n",
+			[]Entry{{Name: "カレー屋", Lat: "35.69", Lng: "139.70", Address: "新宿区", Note: "大盛り", Tags: []string{"ランチ", "辛い"}, MyRating: 4}},
+		},
+		{
+			// 大文字や別名の列名も読む
+			"header names",
+			"Name,Latitude,Longitude,Memo\n寿司屋,35.6,139.7,おまかせ\n",
+			[]Entry{{Name: "寿司屋", Lat: "35.6", Lng: "139.7", Note: "おまかせ"}},
+		},
+		{
+			// 列の足りない行や評価の読めない行も読めるところまで読み，名前もIDもない行は飛ばす
+			"malformed rows",
+			"name,lat,lng,address,url,tags,memo,my_rating\n焼肉屋\n,35.6,139.7\nそば屋,35.6,139.7,,,,,とても良い\n\"うどん屋\"\"\",,,\n",
+			[]Entry{
+				{Name: "焼肉屋"},
+				{Name: "そば屋", Lat: "35.6", Lng: "139.7"},
+				{Name: "うどん屋\""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseCSV(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	geoJSON := `{"features":[{"geometry":{"coordinates":[139.7,35.6]},"properties":{"name":"寿司屋"}}]}`
+	csv := "Title,Note,URL\n寿司屋,,\n"
+	want := []Entry{{Name: "寿司屋"}}
+	tests := []struct {
+		filename string
+		data     string
+		want     []Entry
+		err      error
+	}{
+		{"Saved Places.json", geoJSON, []Entry{{Name: "寿司屋", Lat: "35.6", Lng: "139.7"}}, nil},
+		{"favorites.geojson", geoJSON, []Entry{{Name: "寿司屋", Lat: "35.6", Lng: "139.7"}}, nil},
+		{"行きたい.CSV", csv, want, nil},
+		// 拡張子がなければ中身で判断する
+		{"upload", "\ufeff \n" + geoJSON, []Entry{{Name: "寿司屋", Lat: "35.6", Lng: "139.7"}}, nil},
+		{"upload", csv, want, nil},
+		{"upload", "", nil, ErrUnknownFormat},
+		{"list.csv", "id,label\n1,寿司屋\n", nil, ErrUnknownFormat},
+		{"list.csv", "Title,Note,URL\n", nil, ErrNoEntries},
+		{"places.json", `{"features":[]}`, nil, ErrNoEntries},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.filename, strings.NewReader(tt.data))
+		if err != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.filename, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.filename, got, tt.want)
+		}
+	}
+
+	// 壊れたJSONはエラー
+	if _, err := Parse("places.json", strings.NewReader(`{"features":[`)); err == nil {
+		t.Error("Parse() with broken JSON succeeded")
+	}
+}
+
+func TestEntryLocation(t *testing.T) {
+	tests := []struct {
+		entry Entry
+		want  bool
+	}{
+		// 場所IDがなくても座標があれば場所がわかる
+		{Entry{Name: "寿司屋", Lat: "35.6", Lng: "139.7"}, true},
+		// Takeoutでは座標不明のとき0,0
+		{Entry{Name: "寿司屋", Lat: "0", Lng: "0"}, false},
+		{Entry{Name: "寿司屋"}, false},
+		{Entry{Name: "寿司屋", Lat: "北緯35度", Lng: "139.7"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.entry.HasLocation(); got != tt.want {
+			t.Errorf("HasLocation(%+v) = %v, want %v", tt.entry, got, tt.want)
+		}
+	}
+	e := Entry{Name: "寿司屋", Address: "渋谷区"}
+	if got := e.Query(); got != "寿司屋 渋谷区" {
+		t.Errorf("Query() = %q, want %q", got, "寿司屋 渋谷区")
+	}
+}